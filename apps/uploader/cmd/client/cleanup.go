@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"s3uploader/internal/client"
+)
+
+// runCleanup runs auto-cleanup once, regardless of cleanup.enabled, and
+// prints what it did or, with --dry-run, what it would do.
+func runCleanup(args []string) {
+	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to config file")
+	dryRun := fs.Bool("dry-run", false, "only report what would be deleted")
+	jsonOut := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args)
+
+	if *configPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: s3up cleanup --config <path> [--dry-run] [--json]")
+		os.Exit(1)
+	}
+
+	cfg, err := client.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	db, err := client.NewDB(cfg.Database.Path)
+	if err != nil {
+		log.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	cleaner := client.NewCleaner(db, client.NewUploader(cfg, db), cfg)
+	entries, err := cleaner.RunOnce(*dryRun)
+	if err != nil {
+		log.Fatalf("cleanup failed: %v", err)
+	}
+
+	if *jsonOut {
+		if entries == nil {
+			entries = []client.CleanupEntry{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(entries)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tUPLOADED\tSIZE\tPATH\tREASON")
+	for _, e := range entries {
+		uploaded := time.Unix(e.UploadedAt, 0).Format("2006-01-02")
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", e.Action, uploaded, e.Size, e.LocalPath, e.Reason)
+	}
+	w.Flush()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"s3uploader/internal/client"
+)
+
+// loadControl parses a subcommand's flags and returns the config and a
+// client for the daemon's control socket.
+func loadControl(fs *flag.FlagSet, args []string, minArgs int, usage string) (*client.Config, *client.ControlClient) {
+	configPath := fs.String("config", "", "path to config file")
+	fs.Parse(args)
+
+	if *configPath == "" || fs.NArg() < minArgs {
+		fmt.Fprintln(os.Stderr, "Usage: "+usage)
+		os.Exit(1)
+	}
+
+	cfg, err := client.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	return cfg, client.NewControlClient(cfg.Control.Socket)
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func runQueue(args []string) {
+	fs := flag.NewFlagSet("queue", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "print the queue as JSON")
+	_, control := loadControl(fs, args, 0, "s3up queue --config <path> [--json]")
+
+	snapshot, err := control.Queue()
+	if err != nil {
+		log.Fatalf("queue failed: %v", err)
+	}
+
+	if *jsonOut {
+		printJSON(snapshot)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATE\tATTEMPTS\tNOT BEFORE\tPATH")
+	for _, path := range snapshot.InFlight {
+		fmt.Fprintf(w, "processing\t\t\t%s\n", path)
+	}
+	for _, e := range snapshot.Entries {
+		notBefore := ""
+		if e.NotBefore > 0 {
+			notBefore = formatUnix(e.NotBefore)
+		}
+		fmt.Fprintf(w, "queued\t%d\t%s\t%s\n", e.AttemptCount, notBefore, e.LocalPath)
+	}
+	w.Flush()
+}
+
+func runPause(args []string) {
+	fs := flag.NewFlagSet("pause", flag.ExitOnError)
+	_, control := loadControl(fs, args, 0, "s3up pause --config <path>")
+
+	if err := control.Pause(); err != nil {
+		log.Fatalf("pause failed: %v", err)
+	}
+	fmt.Println("uploads paused")
+}
+
+func runResume(args []string) {
+	fs := flag.NewFlagSet("resume", flag.ExitOnError)
+	_, control := loadControl(fs, args, 0, "s3up resume --config <path>")
+
+	if err := control.Resume(); err != nil {
+		log.Fatalf("resume failed: %v", err)
+	}
+	fmt.Println("uploads resumed")
+}
+
+func runReupload(args []string) {
+	fs := flag.NewFlagSet("reupload", flag.ExitOnError)
+	_, control := loadControl(fs, args, 1, "s3up reupload --config <path> <file|dir>")
+
+	// The daemon has its own working directory.
+	localPath, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	queued, err := control.Reupload(localPath)
+	if err != nil {
+		log.Fatalf("reupload failed: %v", err)
+	}
+	fmt.Printf("queued %d files for upload\n", queued)
+}
+
+func runRescan(args []string) {
+	fs := flag.NewFlagSet("rescan", flag.ExitOnError)
+	_, control := loadControl(fs, args, 0, "s3up rescan --config <path>")
+
+	queued, err := control.Rescan()
+	if err != nil {
+		log.Fatalf("rescan failed: %v", err)
+	}
+	fmt.Printf("rescan queued %d new or changed files\n", queued)
+}
+
+// runFailed lists failed uploads, from the database when no daemon is
+// running.
+func runFailed(args []string) {
+	fs := flag.NewFlagSet("failed", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "print the failures as JSON")
+	cfg, control := loadControl(fs, args, 0, "s3up failed --config <path> [--json]")
+
+	failures, err := control.Failed()
+	if errors.Is(err, client.ErrDaemonNotRunning) {
+		var status *client.Status
+		if status, err = statusFromDB(cfg, -1); err == nil {
+			failures = status.Failures
+		}
+	}
+	if err != nil {
+		log.Fatalf("failed to list failures: %v", err)
+	}
+
+	if *jsonOut {
+		printJSON(failures)
+		return
+	}
+	printFailures(failures)
+}
@@ -6,46 +6,70 @@ import (
 	"log"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
-	"time"
 
 	"s3uploader/internal/client"
 )
 
-func durationUntilNext(hour int) time.Duration {
-	now := time.Now()
-	next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, now.Location())
-	if !next.After(now) {
-		next = next.Add(24 * time.Hour)
-	}
-	return next.Sub(now)
-}
-
-func retryRestartLoop(processor *client.Processor, processorDone <-chan struct{}) {
-	for {
-		time.Sleep(durationUntilNext(1))
-
-		if !processor.HasFailures() {
-			continue
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "restore":
+			runRestore(os.Args[2:])
+			return
+		case "cleanup":
+			runCleanup(os.Args[2:])
+			return
+		case "get":
+			runGet(os.Args[2:])
+			return
+		case "ls":
+			runLs(os.Args[2:])
+			return
+		case "rm":
+			runRm(os.Args[2:])
+			return
+		case "status":
+			runStatus(os.Args[2:])
+			return
+		case "queue":
+			runQueue(os.Args[2:])
+			return
+		case "failed":
+			runFailed(os.Args[2:])
+			return
+		case "pause":
+			runPause(os.Args[2:])
+			return
+		case "resume":
+			runResume(os.Args[2:])
+			return
+		case "reupload":
+			runReupload(os.Args[2:])
+			return
+		case "rescan":
+			runRescan(os.Args[2:])
+			return
 		}
-
-		files := processor.FailedFiles()
-		log.Printf("exiting due to failed uploads, sample failed files: [%s]. service will restart and rescan.",
-			strings.Join(files, ", "))
-
-		processor.Stop()
-		<-processorDone
-		os.Exit(1)
 	}
+	runDaemon()
 }
 
-func main() {
+func runDaemon() {
 	configPath := flag.String("config", "", "path to config file")
 	flag.Parse()
 
 	if *configPath == "" {
 		fmt.Fprintln(os.Stderr, "Usage: s3up --config <path>")
+		fmt.Fprintln(os.Stderr, "       s3up restore --config <path> --watch <name|path> --at <time> --dest <dir>")
+		fmt.Fprintln(os.Stderr, "       s3up cleanup --config <path> [--dry-run]")
+		fmt.Fprintln(os.Stderr, "       s3up get --config <path> [-o <file>] <remote_path>")
+		fmt.Fprintln(os.Stderr, "       s3up ls --config <path> [--json] [prefix]")
+		fmt.Fprintln(os.Stderr, "       s3up rm --config <path> [--yes] <prefix>")
+		fmt.Fprintln(os.Stderr, "       s3up status --config <path> [--json]")
+		fmt.Fprintln(os.Stderr, "       s3up queue|failed --config <path> [--json]")
+		fmt.Fprintln(os.Stderr, "       s3up pause|resume|rescan --config <path>")
+		fmt.Fprintln(os.Stderr, "       s3up reupload --config <path> <file|dir>")
 		os.Exit(1)
 	}
 
@@ -60,8 +84,23 @@ func main() {
 	}
 	defer db.Close()
 
-	queue := client.NewQueue()
-	uploader := client.NewUploader(cfg)
+	queue, err := client.NewPersistentQueue(db)
+	if err != nil {
+		log.Fatalf("failed to load upload queue: %v", err)
+	}
+	if n := queue.Len(); n > 0 {
+		log.Printf("restored %d queued files from previous run", n)
+	}
+	uploader := client.NewUploader(cfg, db)
+
+	// Listening first also keeps a second daemon from starting on the same
+	// database.
+	processor := client.NewProcessor(queue, db, uploader, cfg)
+	control := client.NewControlServer(queue, db, processor, cfg)
+	if err := control.Listen(cfg.Control.Socket); err != nil {
+		log.Fatalf("failed to start control socket: %v", err)
+	}
+	defer control.Close()
 
 	watcher, err := client.NewWatcher(queue, cfg)
 	if err != nil {
@@ -73,25 +112,45 @@ func main() {
 		log.Fatalf("failed to start watcher: %v", err)
 	}
 
-	scanner := client.NewScanner(queue, cfg)
+	scanner := client.NewScanner(queue, db, cfg)
 	if err := scanner.Scan(); err != nil {
 		log.Fatalf("failed to scan directories: %v", err)
 	}
 
+	if err := uploader.ResumePending(queue); err != nil {
+		log.Fatalf("failed to resume pending uploads: %v", err)
+	}
+
 	log.Printf("client started, watching %d directories", len(cfg.Watches))
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
-	processor := client.NewProcessor(queue, db, uploader, cfg)
 	processorDone := make(chan struct{})
 	go func() {
 		processor.Run(nil)
 		close(processorDone)
 	}()
 
-	go retryRestartLoop(processor, processorDone)
+	schedulerStop := make(chan struct{})
+	retries := client.NewRetryScheduler(queue, db, cfg)
+	go retries.Run(schedulerStop)
+	sweeper := client.NewTombstoneSweeper(db, uploader, cfg)
+	go sweeper.Run(schedulerStop)
+	reporter := client.NewReporter(queue, db, uploader)
+	go reporter.Run(schedulerStop)
+	if cfg.Cleanup.Enabled {
+		cleaner := client.NewCleaner(db, uploader, cfg)
+		go cleaner.Run(schedulerStop)
+	}
+	if cfg.Scan.IntervalMinutes > 0 {
+		reconciler := client.NewReconciler(queue, db, cfg)
+		go reconciler.Run(schedulerStop)
+	}
 
 	<-stop
 	log.Println("shutting down")
+	close(schedulerStop)
+	processor.Stop()
+	<-processorDone
 }
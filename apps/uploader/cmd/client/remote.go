@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"s3uploader/internal/client"
+)
+
+// loadRemote parses a subcommand's flags and returns an uploader for its
+// config, exiting with usage when --config or the positional arguments are
+// missing.
+func loadRemote(fs *flag.FlagSet, args []string, minArgs int, usage string) *client.Uploader {
+	configPath := fs.String("config", "", "path to config file")
+	fs.Parse(args)
+
+	if *configPath == "" || fs.NArg() < minArgs {
+		fmt.Fprintln(os.Stderr, "Usage: "+usage)
+		os.Exit(1)
+	}
+
+	cfg, err := client.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	return client.NewUploader(cfg, nil)
+}
+
+func runGet(args []string) {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	output := fs.String("o", "", "write to this file instead of stdout")
+	versionID := fs.String("version", "", "download this version instead of the current one")
+	uploader := loadRemote(fs, args, 1, "s3up get --config <path> [-o <file>] [--version <id>] <remote_path>")
+	remotePath := fs.Arg(0)
+
+	if *output == "" {
+		if _, err := uploader.Download(remotePath, *versionID, os.Stdout); err != nil {
+			log.Fatalf("get failed: %v", err)
+		}
+		return
+	}
+
+	// Download next to the destination and rename, so a failed download
+	// never leaves a truncated file behind.
+	tmp, err := os.CreateTemp(filepath.Dir(*output), ".s3up-get-*")
+	if err != nil {
+		log.Fatalf("get failed: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = uploader.Download(remotePath, *versionID, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), *output)
+	}
+	if err != nil {
+		log.Fatalf("get failed: %v", err)
+	}
+}
+
+func runLs(args []string) {
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "print the listing as JSON")
+	uploader := loadRemote(fs, args, 0, "s3up ls --config <path> [--json] [prefix]")
+
+	files, err := uploader.List(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("list failed: %v", err)
+	}
+
+	if *jsonOut {
+		if files == nil {
+			files = []client.RemoteFile{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(files)
+		return
+	}
+
+	var total int64
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "SIZE\tPATH\t")
+	for _, f := range files {
+		fmt.Fprintf(w, "%d\t%s\t\n", f.Size, f.Path)
+		total += f.Size
+	}
+	w.Flush()
+	fmt.Printf("%d files, %d bytes\n", len(files), total)
+}
+
+func runRm(args []string) {
+	fs := flag.NewFlagSet("rm", flag.ExitOnError)
+	yes := fs.Bool("yes", false, "delete without asking for confirmation")
+	uploader := loadRemote(fs, args, 1, "s3up rm --config <path> [--yes] <prefix>")
+	prefix := fs.Arg(0)
+
+	if !*yes {
+		files, err := uploader.List(prefix)
+		if err != nil {
+			log.Fatalf("list failed: %v", err)
+		}
+		if len(files) == 0 {
+			fmt.Printf("nothing stored under %s\n", prefix)
+			return
+		}
+		if !confirm(os.Stdin, os.Stderr, fmt.Sprintf("Delete %d files under %s?", len(files), prefix)) {
+			fmt.Fprintln(os.Stderr, "aborted")
+			os.Exit(1)
+		}
+	}
+
+	deleted, err := uploader.DeletePrefix(prefix)
+	if err != nil {
+		log.Fatalf("delete failed: %v", err)
+	}
+	fmt.Printf("deleted %d files under %s\n", deleted, prefix)
+}
+
+// confirm asks a yes/no question and treats anything but y or yes,
+// including end of input, as no.
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
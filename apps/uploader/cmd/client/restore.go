@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"s3uploader/internal/client"
+)
+
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseTime accepts RFC 3339 or a date with an optional time, which is
+// taken as local time.
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or YYYY-MM-DD[ HH:MM[:SS]]", s)
+}
+
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to config file")
+	watchName := fs.String("watch", "", "watch to restore, by local_path or remote_prefix")
+	at := fs.String("at", "", "restore files as they were at this time (default now)")
+	dest := fs.String("dest", "", "directory to restore into")
+	parallel := fs.Int("parallel", 4, "number of parallel downloads")
+	reportPath := fs.String("report", "", "where to write the JSON report (default <dest>/s3up-restore-report.json)")
+	fs.Parse(args)
+
+	if *configPath == "" || *watchName == "" || *dest == "" {
+		fmt.Fprintln(os.Stderr, "Usage: s3up restore --config <path> --watch <name|path> --at <time> --dest <dir>")
+		os.Exit(1)
+	}
+
+	cfg, err := client.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	watch := cfg.FindWatch(*watchName)
+	if watch == nil {
+		log.Fatalf("no watch matches %q", *watchName)
+	}
+
+	restoreAt := time.Now()
+	if *at != "" {
+		if restoreAt, err = parseTime(*at); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *reportPath == "" {
+		*reportPath = filepath.Join(*dest, "s3up-restore-report.json")
+	}
+
+	report, err := client.Restore(client.NewUploader(cfg, nil), client.RestoreOptions{
+		Watch:    watch,
+		At:       restoreAt,
+		Dest:     *dest,
+		Parallel: *parallel,
+	})
+	if err != nil {
+		log.Fatalf("restore failed: %v", err)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Fatalf("failed to encode report: %v", err)
+	}
+	if err := os.WriteFile(*reportPath, data, 0644); err != nil {
+		log.Fatalf("failed to write report: %v", err)
+	}
+
+	fmt.Printf("restored %d files, skipped %d, failed %d (report: %s)\n", report.Restored, report.Skipped, report.Failed, *reportPath)
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
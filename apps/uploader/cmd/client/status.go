@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"s3uploader/internal/client"
+)
+
+// runStatus asks the running daemon for its status, or reads the database
+// directly when no daemon is running.
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "print the status as JSON")
+	failures := fs.Int("failures", 10, "number of recent failures to show")
+	cfg, control := loadControl(fs, args, 0, "s3up status --config <path> [--json] [--failures <n>]")
+
+	status, err := control.Status(*failures)
+	if errors.Is(err, client.ErrDaemonNotRunning) {
+		status, err = statusFromDB(cfg, *failures)
+	}
+	if err != nil {
+		log.Fatalf("failed to read status: %v", err)
+	}
+
+	if *jsonOut {
+		printJSON(status)
+		return
+	}
+	printStatus(status)
+}
+
+// statusFromDB reads the status without a daemon. The database is opened
+// read-only; if the daemon never ran there is none, and that is reported.
+func statusFromDB(cfg *client.Config, maxFailures int) (*client.Status, error) {
+	db, err := client.OpenDBReadOnly(cfg.Database.Path)
+	if errors.Is(err, client.ErrNoDatabase) {
+		return nil, fmt.Errorf("daemon is not running and has never run: %w", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return client.CollectStatus(db, cfg, maxFailures)
+}
+
+func formatUnix(ts int64) string {
+	return time.Unix(ts, 0).Format("2006-01-02 15:04:05")
+}
+
+func printStatus(status *client.Status) {
+	switch {
+	case !status.DaemonRunning:
+		fmt.Println("Daemon:   not running")
+	case status.Paused:
+		fmt.Println("Daemon:   running, uploads paused")
+	default:
+		fmt.Println("Daemon:   running")
+	}
+	fmt.Printf("Uploaded: %d files, %d bytes\n", status.Uploaded, status.UploadedBytes)
+	fmt.Printf("Queued:   %d files\n", status.Queued)
+
+	reasons := make([]string, 0, len(status.Skipped))
+	for reason := range status.Skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	fmt.Println("\nSkipped:")
+	if len(reasons) == 0 {
+		fmt.Println("  none")
+	}
+	for _, reason := range reasons {
+		fmt.Printf("  %-20s %d\n", reason, status.Skipped[reason])
+	}
+
+	fmt.Println("\nWatches:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  PATH\tPREFIX\tUPLOADED\tBYTES\tLAST UPLOAD")
+	for _, ws := range status.Watches {
+		last := "never"
+		if ws.LastUploadedAt != nil {
+			last = formatUnix(*ws.LastUploadedAt)
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%d\t%d\t%s\n", ws.LocalPath, ws.RemotePrefix, ws.Uploaded, ws.UploadedBytes, last)
+	}
+	w.Flush()
+
+	fmt.Printf("\nFailures: %d\n", status.FailureCount)
+	printFailures(status.Failures)
+}
+
+func printFailures(failures []client.FailureInfo) {
+	if len(failures) == 0 {
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  PATH\tATTEMPTS\tLAST FAILED\tNEXT RETRY\tERROR")
+	for _, f := range failures {
+		next := "on change"
+		if f.NextRetryAt > 0 {
+			next = formatUnix(f.NextRetryAt)
+		}
+		fmt.Fprintf(w, "  %s\t%d\t%s\t%s\t%s\n", f.LocalPath, f.AttemptCount, formatUnix(f.LastFailedAt), next, f.Error)
+	}
+	w.Flush()
+}
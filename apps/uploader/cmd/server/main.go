@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"s3uploader/internal/server"
 )
@@ -49,12 +50,51 @@ func main() {
 	defer watcher.Close()
 
 	handler := server.NewHandler(s3Client, db)
+	handler.SetMaxVersions(cfg.Versioning.MaxVersions)
+	handler.SetWebhooks(cfg.Webhooks)
+	handler.Health().SetThresholds(
+		time.Duration(cfg.ClientHealth.StaleAfterMinutes)*time.Minute,
+		time.Duration(cfg.ClientHealth.ReportStaleAfterHours)*time.Hour)
 
 	mux := http.NewServeMux()
 	handler.RegisterRoutes(mux, auth)
+	if len(cfg.Admin.APIKeys) > 0 {
+		handler.RegisterAdminRoutes(mux, server.NewAdminAuth(cfg.Admin.APIKeys), auth)
+	}
+
+	if db != nil {
+		dispatcher := server.NewWebhookDispatcher(db)
+		dispatcher.SetEndpoints(cfg.Webhooks)
+		dispatcher.SetAlertSecret(cfg.Alerts.Secret)
+		go dispatcher.Run(nil)
+	}
+	if len(cfg.Alerts.Webhooks) > 0 {
+		go server.NewAlerter(db, handler.Health(), auth, cfg.Alerts).Run(nil)
+	}
 
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
-	log.Printf("starting server on %s", addr)
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	if !cfg.Server.TLS.Enabled {
+		log.Printf("starting server on %s", addr)
+		log.Fatal(srv.ListenAndServe())
+	}
+
+	certs, err := server.NewCertReloader(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+	if err != nil {
+		log.Fatalf("failed to load tls certificate: %v", err)
+	}
+	certWatcher, err := certs.Watch()
+	if err != nil {
+		log.Fatalf("failed to start tls certificate watcher: %v", err)
+	}
+	defer certWatcher.Close()
+
+	srv.TLSConfig, err = server.NewTLSConfig(cfg.Server.TLS, certs)
+	if err != nil {
+		log.Fatalf("invalid tls config: %v", err)
+	}
 
-	log.Fatal(http.ListenAndServe(addr, mux))
+	log.Printf("starting server on %s with tls", addr)
+	log.Fatal(srv.ListenAndServeTLS("", ""))
 }
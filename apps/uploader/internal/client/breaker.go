@@ -0,0 +1,140 @@
+package client
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// isServerError reports whether err means the server as a whole is
+// unreachable or failing, as opposed to a problem with one file.
+func isServerError(err error) bool {
+	// Local read failures and changed files surface through the transport
+	// as *url.Error too, but they are about the file, not the server.
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) || errors.Is(err, ErrChecksumMismatch) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// withJitter spreads d uniformly over [d/2, d] so clients that lost the
+// server at the same moment do not all come back at once.
+func withJitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// CircuitBreaker pauses uploads while the server is down. The first server
+// error opens it; it then probes GET /health with exponential backoff and
+// jitter and closes again once the server answers.
+type CircuitBreaker struct {
+	uploader *Uploader
+	initial  time.Duration
+	max      time.Duration
+
+	mu   sync.Mutex
+	open bool
+}
+
+func NewCircuitBreaker(uploader *Uploader, cfg *Config) *CircuitBreaker {
+	initial := time.Duration(cfg.Upload.OutageProbeInitialSeconds) * time.Second
+	if initial <= 0 {
+		initial = time.Second
+	}
+	max := time.Duration(cfg.Upload.OutageProbeMaxSeconds) * time.Second
+	if max < initial {
+		max = initial
+	}
+	return &CircuitBreaker{
+		uploader: uploader,
+		initial:  initial,
+		max:      max,
+	}
+}
+
+func (b *CircuitBreaker) IsOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
+
+// TripIfDown opens the breaker if the server also fails GET /health, and
+// reports whether it is open. A server error from an otherwise healthy
+// server is about the request, so it should count against the file.
+func (b *CircuitBreaker) TripIfDown(cause error) bool {
+	if b.IsOpen() {
+		return true
+	}
+	if err := b.uploader.Health(); err == nil {
+		return false
+	}
+	b.Trip(cause)
+	return true
+}
+
+// Trip opens the breaker after a server error and starts probing.
+func (b *CircuitBreaker) Trip(cause error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.open {
+		return
+	}
+	b.open = true
+	log.Printf("server unavailable, pausing uploads: %v", cause)
+	go b.probe()
+}
+
+func (b *CircuitBreaker) probe() {
+	delay := b.initial
+	start := time.Now()
+	for {
+		time.Sleep(withJitter(delay))
+
+		if err := b.uploader.Health(); err == nil {
+			b.mu.Lock()
+			b.open = false
+			b.mu.Unlock()
+			log.Printf("server is back after %s, resuming uploads", time.Since(start).Round(time.Second))
+			return
+		}
+
+		delay *= 2
+		if delay > b.max {
+			delay = b.max
+		}
+	}
+}
+
+func (u *Uploader) Health() error {
+	req, err := http.NewRequest("GET", u.cfg.Server.URL+"/health", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Op: "health check", StatusCode: resp.StatusCode}
+	}
+	return nil
+}
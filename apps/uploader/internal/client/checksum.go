@@ -0,0 +1,44 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+)
+
+// ErrChecksumMismatch means the bytes that reached the server differ from
+// the local file. It is retryable: the file usually changed mid-upload.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// hashSection returns the hex SHA-256 of n bytes of r starting at off.
+func hashSection(r io.ReaderAt, off, n int64) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, off, n)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyingReader hashes what it streams and fails at EOF if the result
+// differs from the digest computed before the upload started, which means
+// the file changed while it was being sent.
+type verifyingReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	expected string
+}
+
+func newVerifyingReader(r io.Reader, expected string) *verifyingReader {
+	return &verifyingReader{r: r, hash: sha256.New(), expected: expected}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(v.hash.Sum(nil)) != v.expected {
+		return n, ErrChecksumMismatch
+	}
+	return n, err
+}
@@ -0,0 +1,160 @@
+package client
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	CleanupDeleted     = "deleted"
+	CleanupWouldDelete = "would_delete"
+	CleanupKept        = "kept"
+)
+
+type CleanupEntry struct {
+	LocalPath  string `json:"local_path"`
+	RemotePath string `json:"remote_path"`
+	Size       int64  `json:"size"`
+	UploadedAt int64  `json:"uploaded_at"`
+	Action     string `json:"action"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// Cleaner deletes local files that were uploaded cleanup.after_days ago,
+// once a day at cleanup.time, after checking that the server still has
+// them.
+type Cleaner struct {
+	db       *DB
+	uploader *Uploader
+	cfg      *Config
+}
+
+func NewCleaner(db *DB, uploader *Uploader, cfg *Config) *Cleaner {
+	return &Cleaner{
+		db:       db,
+		uploader: uploader,
+		cfg:      cfg,
+	}
+}
+
+// nextDailyRun returns the next time after now at the HH:MM clock time.
+func nextDailyRun(now time.Time, clock string) time.Time {
+	t, _ := time.Parse("15:04", clock)
+	next := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func (c *Cleaner) Run(stop <-chan struct{}) {
+	for {
+		next := nextDailyRun(time.Now(), c.cfg.Cleanup.Time)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		entries, err := c.RunOnce(c.cfg.Cleanup.DryRun)
+		if err != nil {
+			log.Printf("cleanup failed: %v", err)
+			continue
+		}
+		logCleanup(entries)
+	}
+}
+
+func logCleanup(entries []CleanupEntry) {
+	counts := make(map[string]int)
+	for _, e := range entries {
+		counts[e.Action]++
+		if e.Action == CleanupWouldDelete {
+			log.Printf("cleanup (dry run): would delete %s", e.LocalPath)
+		}
+	}
+	log.Printf("cleanup: deleted %d, would delete %d, kept %d", counts[CleanupDeleted], counts[CleanupWouldDelete], counts[CleanupKept])
+}
+
+// RunOnce deletes, or with dryRun only reports, every local file uploaded
+// at least after_days ago that is unchanged since and whose server copy
+// matches it.
+func (c *Cleaner) RunOnce(dryRun bool) ([]CleanupEntry, error) {
+	cutoff := time.Now().AddDate(0, 0, -c.cfg.Cleanup.AfterDays).UTC().Unix()
+	recs, err := c.db.CleanupCandidates(cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []CleanupEntry
+	for _, rec := range recs {
+		info, err := os.Stat(rec.LocalPath)
+		if err != nil {
+			// Already gone; nothing to clean up.
+			continue
+		}
+
+		entry := CleanupEntry{
+			LocalPath:  rec.LocalPath,
+			RemotePath: rec.RemotePath,
+			Size:       rec.FileSize,
+			UploadedAt: *rec.UploadedAt,
+			Action:     CleanupKept,
+		}
+		entry.Reason = c.keepReason(&rec, info)
+		if entry.Reason != "" {
+			entries = append(entries, entry)
+			continue
+		}
+
+		if dryRun {
+			entry.Action = CleanupWouldDelete
+			entries = append(entries, entry)
+			continue
+		}
+
+		// Flag the row first so the watcher's remove event is not mirrored
+		// as a deletion.
+		if err := c.db.SetLocallyDeleted(rec.LocalPath, true); err != nil {
+			return entries, err
+		}
+		if err := os.Remove(rec.LocalPath); err != nil {
+			c.db.SetLocallyDeleted(rec.LocalPath, false)
+			entry.Reason = err.Error()
+			entries = append(entries, entry)
+			continue
+		}
+		entry.Action = CleanupDeleted
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// keepReason returns why a candidate must not be deleted, or "".
+func (c *Cleaner) keepReason(rec *FileRecord, info os.FileInfo) string {
+	if c.cfg.WatchFor(rec.LocalPath) == nil {
+		return "not in a watched directory"
+	}
+	if info.ModTime().UTC().Unix() != rec.Mtime || info.Size() != rec.FileSize {
+		return "changed since upload"
+	}
+
+	stat, err := c.uploader.Stat(rec.RemotePath)
+	if err != nil {
+		return "exists check failed: " + err.Error()
+	}
+	if !stat.Exists {
+		return "not found on server"
+	}
+	if stat.Size != rec.FileSize {
+		return "server copy differs in size"
+	}
+	if stat.SHA256 != "" && (rec.SHA256 == nil || !strings.EqualFold(*rec.SHA256, stat.SHA256)) {
+		return "server copy differs in sha256"
+	}
+	return ""
+}
@@ -6,10 +6,15 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// minChunkSizeMB is the smallest part size S3 accepts for all but the last
+// part of a multipart upload.
+const minChunkSizeMB = 5
+
 type Config struct {
 	Server          ServerConfig    `yaml:"server"`
 	Database        DatabaseConfig  `yaml:"database"`
@@ -17,14 +22,22 @@ type Config struct {
 	Scan            ScanConfig      `yaml:"scan"`
 	Stability       StabilityConfig `yaml:"stability"`
 	Upload          UploadConfig    `yaml:"upload"`
+	Cleanup         CleanupConfig   `yaml:"cleanup"`
+	Control         ControlConfig   `yaml:"control"`
 	ExcludePatterns []string        `yaml:"exclude_patterns"`
 
 	excludeRegexps []*regexp.Regexp
 }
 
+// ServerConfig says where the server is and how to authenticate: with
+// APIKey, with the client certificate in CertFile and KeyFile, or both.
+// CAFile verifies the server's certificate instead of the system roots.
 type ServerConfig struct {
-	URL    string `yaml:"url"`
-	APIKey string `yaml:"api_key"`
+	URL      string `yaml:"url"`
+	APIKey   string `yaml:"api_key"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	CAFile   string `yaml:"ca_file"`
 }
 
 type DatabaseConfig struct {
@@ -34,10 +47,30 @@ type DatabaseConfig struct {
 type WatchConfig struct {
 	LocalPath    string `yaml:"local_path"`
 	RemotePrefix string `yaml:"remote_prefix"`
+	// SkipUnchanged re-hashes a previously uploaded file whose mtime changed
+	// and skips the upload when its content is identical.
+	SkipUnchanged bool `yaml:"skip_unchanged"`
+	// MirrorDeletes removes a deleted file from the server once it has been
+	// gone for DeleteGraceSeconds, and turns renames into server-side copies.
+	MirrorDeletes      bool `yaml:"mirror_deletes"`
+	DeleteGraceSeconds int  `yaml:"delete_grace_seconds"`
 }
 
+const (
+	ScanModeUpload   = "upload"
+	ScanModeSkip     = "skip"
+	ScanModeBaseline = "baseline"
+)
+
 type ScanConfig struct {
-	UploadExisting bool `yaml:"upload_existing"`
+	// Mode decides what happens to files that already exist when a watch is
+	// first seen: upload them, skip them, or record them as a baseline.
+	// When empty it follows UploadExisting.
+	Mode           string `yaml:"mode"`
+	UploadExisting bool   `yaml:"upload_existing"`
+	// IntervalMinutes runs a reconciling rescan of every watch this often;
+	// zero disables it.
+	IntervalMinutes int `yaml:"interval_minutes"`
 }
 
 type StabilityConfig struct {
@@ -49,6 +82,28 @@ type UploadConfig struct {
 	RetryAttempts     int `yaml:"retry_attempts"`
 	RetryDelaySeconds int `yaml:"retry_delay_seconds"`
 	MaxFileSizeMB     int `yaml:"max_file_size_mb"`
+	ChunkSizeMB       int `yaml:"chunk_size_mb"`
+	Concurrent        int `yaml:"concurrent"`
+
+	FailedRetryInitialSeconds int `yaml:"failed_retry_initial_seconds"`
+	FailedRetryMaxSeconds     int `yaml:"failed_retry_max_seconds"`
+
+	OutageProbeInitialSeconds int `yaml:"outage_probe_initial_seconds"`
+	OutageProbeMaxSeconds     int `yaml:"outage_probe_max_seconds"`
+}
+
+// CleanupConfig controls deleting local files some days after upload.
+type CleanupConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	AfterDays int    `yaml:"after_days"`
+	Time      string `yaml:"time"`
+	// DryRun only logs what would be deleted.
+	DryRun bool `yaml:"dry_run"`
+}
+
+// ControlConfig sets where the daemon serves its control API.
+type ControlConfig struct {
+	Socket string `yaml:"socket"`
 }
 
 func expandTilde(p, home string) string {
@@ -71,6 +126,10 @@ func LoadConfig(path string) (*Config, error) {
 
 	home, _ := os.UserHomeDir()
 	cfg.Database.Path = expandTilde(cfg.Database.Path, home)
+	cfg.Control.Socket = expandTilde(cfg.Control.Socket, home)
+	cfg.Server.CertFile = expandTilde(cfg.Server.CertFile, home)
+	cfg.Server.KeyFile = expandTilde(cfg.Server.KeyFile, home)
+	cfg.Server.CAFile = expandTilde(cfg.Server.CAFile, home)
 	for i := range cfg.Watches {
 		cfg.Watches[i].LocalPath = expandTilde(cfg.Watches[i].LocalPath, home)
 	}
@@ -84,6 +143,19 @@ func LoadConfig(path string) (*Config, error) {
 		}
 	}
 
+	if (cfg.Server.CertFile == "") != (cfg.Server.KeyFile == "") {
+		return nil, fmt.Errorf("server.cert_file and server.key_file must be set together")
+	}
+	if cfg.Server.APIKey == "" && cfg.Server.CertFile == "" {
+		return nil, fmt.Errorf("server needs an api_key or a cert_file and key_file")
+	}
+	if _, err := cfg.Server.TLSConfig(); err != nil {
+		return nil, err
+	}
+
+	if cfg.Control.Socket == "" {
+		cfg.Control.Socket = filepath.Join(filepath.Dir(cfg.Database.Path), "s3up.sock")
+	}
 	if cfg.Stability.DebounceSeconds == 0 {
 		cfg.Stability.DebounceSeconds = 3
 	}
@@ -93,12 +165,64 @@ func LoadConfig(path string) (*Config, error) {
 	if cfg.Upload.RetryAttempts == 0 {
 		cfg.Upload.RetryAttempts = 3
 	}
+	if cfg.Upload.RetryAttempts < 0 {
+		return nil, fmt.Errorf("upload.retry_attempts must be positive, got %d", cfg.Upload.RetryAttempts)
+	}
 	if cfg.Upload.RetryDelaySeconds == 0 {
 		cfg.Upload.RetryDelaySeconds = 5
 	}
 	if cfg.Upload.MaxFileSizeMB == 0 {
 		cfg.Upload.MaxFileSizeMB = 100
 	}
+	if cfg.Upload.ChunkSizeMB == 0 {
+		cfg.Upload.ChunkSizeMB = 16
+	}
+	for i := range cfg.Watches {
+		if cfg.Watches[i].MirrorDeletes && cfg.Watches[i].DeleteGraceSeconds == 0 {
+			cfg.Watches[i].DeleteGraceSeconds = 300
+		}
+	}
+	if cfg.Upload.FailedRetryInitialSeconds == 0 {
+		cfg.Upload.FailedRetryInitialSeconds = 60
+	}
+	if cfg.Upload.FailedRetryMaxSeconds == 0 {
+		cfg.Upload.FailedRetryMaxSeconds = 6 * 60 * 60
+	}
+	if cfg.Upload.OutageProbeInitialSeconds == 0 {
+		cfg.Upload.OutageProbeInitialSeconds = 2
+	}
+	if cfg.Upload.OutageProbeMaxSeconds == 0 {
+		cfg.Upload.OutageProbeMaxSeconds = 60
+	}
+	if cfg.Upload.Concurrent == 0 {
+		cfg.Upload.Concurrent = 1
+	}
+	if cfg.Upload.Concurrent < 0 {
+		return nil, fmt.Errorf("upload.concurrent must be positive, got %d", cfg.Upload.Concurrent)
+	}
+	switch cfg.Scan.Mode {
+	case "", ScanModeUpload, ScanModeSkip, ScanModeBaseline:
+	default:
+		return nil, fmt.Errorf("scan.mode must be upload, skip or baseline, got %q", cfg.Scan.Mode)
+	}
+	if cfg.Cleanup.AfterDays == 0 {
+		cfg.Cleanup.AfterDays = 30
+	}
+	if cfg.Cleanup.AfterDays < 0 {
+		return nil, fmt.Errorf("cleanup.after_days must be positive, got %d", cfg.Cleanup.AfterDays)
+	}
+	if cfg.Cleanup.Time == "" {
+		cfg.Cleanup.Time = "03:00"
+	}
+	if _, err := time.Parse("15:04", cfg.Cleanup.Time); err != nil {
+		return nil, fmt.Errorf("cleanup.time must be HH:MM, got %q", cfg.Cleanup.Time)
+	}
+	if cfg.Scan.IntervalMinutes < 0 {
+		return nil, fmt.Errorf("scan.interval_minutes must not be negative, got %d", cfg.Scan.IntervalMinutes)
+	}
+	if cfg.Upload.ChunkSizeMB < minChunkSizeMB {
+		return nil, fmt.Errorf("upload.chunk_size_mb must be at least %d, got %d", minChunkSizeMB, cfg.Upload.ChunkSizeMB)
+	}
 
 	if err := cfg.CompileExcludePatterns(); err != nil {
 		return nil, err
@@ -107,6 +231,16 @@ func LoadConfig(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+func (s ScanConfig) EffectiveMode() string {
+	if s.Mode != "" {
+		return s.Mode
+	}
+	if s.UploadExisting {
+		return ScanModeUpload
+	}
+	return ScanModeSkip
+}
+
 func (c *Config) CompileExcludePatterns() error {
 	c.excludeRegexps = nil
 	for _, pattern := range c.ExcludePatterns {
@@ -127,3 +261,26 @@ func (c *Config) IsExcluded(path string) bool {
 	}
 	return false
 }
+
+// WatchFor returns the watch that contains localPath, or nil.
+func (c *Config) WatchFor(localPath string) *WatchConfig {
+	for i := range c.Watches {
+		w := &c.Watches[i]
+		if localPath == w.LocalPath || strings.HasPrefix(localPath, strings.TrimSuffix(w.LocalPath, "/")+"/") {
+			return w
+		}
+	}
+	return nil
+}
+
+// FindWatch looks a watch up by its local_path or its remote_prefix.
+func (c *Config) FindWatch(name string) *WatchConfig {
+	for i := range c.Watches {
+		w := &c.Watches[i]
+		if filepath.Clean(name) == filepath.Clean(w.LocalPath) ||
+			strings.Trim(name, "/") == strings.Trim(w.RemotePrefix, "/") {
+			return w
+		}
+	}
+	return nil
+}
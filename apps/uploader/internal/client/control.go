@@ -0,0 +1,381 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrDaemonNotRunning is returned by ControlClient when nothing is listening
+// on the control socket.
+var ErrDaemonNotRunning = errors.New("daemon is not running")
+
+type QueuedFile struct {
+	LocalPath    string `json:"local_path"`
+	RemotePath   string `json:"remote_path"`
+	AttemptCount int    `json:"attempt_count"`
+	NotBefore    int64  `json:"not_before,omitempty"`
+}
+
+type QueueSnapshot struct {
+	Entries  []QueuedFile `json:"entries"`
+	InFlight []string     `json:"in_flight"`
+}
+
+// ControlServer serves the daemon's HTTP/JSON control API on a Unix socket.
+type ControlServer struct {
+	queue      *Queue
+	db         *DB
+	cfg        *Config
+	processor  *Processor
+	reconciler *Reconciler
+	server     *http.Server
+	socketPath string
+}
+
+func NewControlServer(queue *Queue, db *DB, processor *Processor, cfg *Config) *ControlServer {
+	return &ControlServer{
+		queue:      queue,
+		db:         db,
+		cfg:        cfg,
+		processor:  processor,
+		reconciler: NewReconciler(queue, db, cfg),
+	}
+}
+
+func (c *ControlServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", c.handleStatus)
+	mux.HandleFunc("/queue", c.handleQueue)
+	mux.HandleFunc("/failed", c.handleFailed)
+	mux.HandleFunc("/pause", c.handlePause)
+	mux.HandleFunc("/resume", c.handlePause)
+	mux.HandleFunc("/reupload", c.handleReupload)
+	mux.HandleFunc("/rescan", c.handleRescan)
+	return mux
+}
+
+// Listen starts serving on socketPath. A socket left behind by a daemon that
+// did not shut down cleanly is replaced; one that still answers is not.
+func (c *ControlServer) Listen(socketPath string) error {
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return fmt.Errorf("another daemon is listening on %s", socketPath)
+	}
+	os.Remove(socketPath)
+
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0755); err != nil {
+		return err
+	}
+
+	// The socket can pause uploads and read every watched path, so only
+	// the daemon's user may connect. It is created inside a private
+	// directory and only moved into place once it is 0600, so nobody can
+	// connect while it still has the umask's permissions.
+	tmpDir, err := os.MkdirTemp(filepath.Dir(socketPath), ".s3up-control-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	tmpPath := filepath.Join(tmpDir, "sock")
+
+	listener, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return err
+	}
+	// Close would unlink tmpPath, which is gone by then; Close removes
+	// socketPath instead.
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+	if err := os.Rename(tmpPath, socketPath); err != nil {
+		listener.Close()
+		return err
+	}
+
+	c.socketPath = socketPath
+	c.server = &http.Server{Handler: c.Handler()}
+	go c.server.Serve(listener)
+	return nil
+}
+
+// Close stops serving and removes the socket.
+func (c *ControlServer) Close() error {
+	if c.server == nil {
+		return nil
+	}
+	err := c.server.Close()
+	if rmErr := os.Remove(c.socketPath); rmErr != nil && !os.IsNotExist(rmErr) && err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (c *ControlServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxFailures := 10
+	if s := r.URL.Query().Get("failures"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, "invalid failures parameter", http.StatusBadRequest)
+			return
+		}
+		maxFailures = n
+	}
+
+	status, err := CollectStatus(c.db, c.cfg, maxFailures)
+	if err != nil {
+		http.Error(w, "status failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status.DaemonRunning = true
+	status.Paused = c.processor.Paused()
+	status.Queued = c.queue.Len()
+	writeJSON(w, status)
+}
+
+func (c *ControlServer) handleQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	entries, inFlight := c.queue.Snapshot()
+	snapshot := QueueSnapshot{
+		Entries:  make([]QueuedFile, 0, len(entries)),
+		InFlight: inFlight,
+	}
+	for _, e := range entries {
+		f := QueuedFile{LocalPath: e.LocalPath, RemotePath: e.RemotePath, AttemptCount: e.AttemptCount}
+		if !e.NotBefore.IsZero() {
+			f.NotBefore = e.NotBefore.Unix()
+		}
+		snapshot.Entries = append(snapshot.Entries, f)
+	}
+	writeJSON(w, snapshot)
+}
+
+func (c *ControlServer) handleFailed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	failed, err := c.db.ListFailedUploads()
+	if err != nil {
+		http.Error(w, "list failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"files": failureInfos(failed)})
+}
+
+// handlePause serves both /pause and /resume.
+func (c *ControlServer) handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.URL.Path == "/pause" {
+		c.processor.Pause()
+	} else {
+		c.processor.Resume()
+	}
+	writeJSON(w, map[string]bool{"paused": c.processor.Paused()})
+}
+
+// handleReupload queues a file, or every file below a directory, for upload
+// even if it has not changed since it was last uploaded.
+func (c *ControlServer) handleReupload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	localPath := filepath.Clean(r.FormValue("path"))
+	if !filepath.IsAbs(localPath) {
+		http.Error(w, "path must be absolute", http.StatusBadRequest)
+		return
+	}
+	watch := c.cfg.WatchFor(localPath)
+	if watch == nil {
+		http.Error(w, "path is not in a watched directory", http.StatusBadRequest)
+		return
+	}
+	if _, err := os.Stat(localPath); err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "path not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "stat failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	queued := 0
+	err := filepath.WalkDir(localPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(watch.LocalPath, path)
+		if err != nil {
+			return nil
+		}
+		remotePath := filepath.Join(watch.RemotePrefix, relPath)
+		if c.cfg.IsExcluded(remotePath) {
+			return nil
+		}
+
+		c.queue.EnqueueEntry(QueueEntry{LocalPath: path, RemotePath: remotePath, Force: true})
+		queued++
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "reupload failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]int{"queued": queued})
+}
+
+func (c *ControlServer) handleRescan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queued, err := c.reconciler.Reconcile()
+	if err != nil {
+		http.Error(w, "rescan failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]int{"queued": queued})
+}
+
+// ControlClient talks to a running daemon over its control socket.
+type ControlClient struct {
+	client *http.Client
+}
+
+func NewControlClient(socketPath string) *ControlClient {
+	return &ControlClient{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+func (c *ControlClient) do(op, method, endpoint string, form url.Values, out interface{}) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	// The host is ignored; every request goes to the socket.
+	req, err := http.NewRequest(method, "http://s3up"+endpoint, body)
+	if err != nil {
+		return err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return ErrDaemonNotRunning
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return &StatusError{Op: op, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(respBody))}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *ControlClient) Status(maxFailures int) (*Status, error) {
+	var status Status
+	query := url.Values{"failures": {strconv.Itoa(maxFailures)}}
+	if err := c.do("status", "GET", "/status?"+query.Encode(), nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (c *ControlClient) Queue() (*QueueSnapshot, error) {
+	var snapshot QueueSnapshot
+	if err := c.do("queue", "GET", "/queue", nil, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+func (c *ControlClient) Failed() ([]FailureInfo, error) {
+	var result struct {
+		Files []FailureInfo `json:"files"`
+	}
+	if err := c.do("failed", "GET", "/failed", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Files, nil
+}
+
+func (c *ControlClient) Pause() error {
+	var result struct{}
+	return c.do("pause", "POST", "/pause", url.Values{}, &result)
+}
+
+func (c *ControlClient) Resume() error {
+	var result struct{}
+	return c.do("resume", "POST", "/resume", url.Values{}, &result)
+}
+
+// Reupload forces an upload of a file, or of every file below a directory,
+// and returns how many files were queued.
+func (c *ControlClient) Reupload(localPath string) (int, error) {
+	var result struct {
+		Queued int `json:"queued"`
+	}
+	if err := c.do("reupload", "POST", "/reupload", url.Values{"path": {localPath}}, &result); err != nil {
+		return 0, err
+	}
+	return result.Queued, nil
+}
+
+// Rescan runs a reconciling rescan of every watch and returns how many
+// files it queued.
+func (c *ControlClient) Rescan() (int, error) {
+	var result struct {
+		Queued int `json:"queued"`
+	}
+	if err := c.do("rescan", "POST", "/rescan", url.Values{}, &result); err != nil {
+		return 0, err
+	}
+	return result.Queued, nil
+}
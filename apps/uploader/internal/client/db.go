@@ -2,11 +2,17 @@ package client
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
+
+	"s3uploader/internal/dbschema"
 )
 
 type DB struct {
@@ -21,6 +27,64 @@ type FileRecord struct {
 	Mtime      int64
 	UploadedAt *int64
 	SkipReason *string
+	SHA256     *string
+	// LocallyDeleted is set once auto-cleanup has removed the local copy.
+	LocallyDeleted bool
+}
+
+type MultipartRecord struct {
+	LocalPath  string
+	RemotePath string
+	UploadID   string
+	FileSize   int64
+	Mtime      int64
+	ChunkSize  int64
+	Parts      map[int32]PartRecord
+}
+
+type FailedUpload struct {
+	LocalPath     string
+	RemotePath    string
+	Error         string
+	AttemptCount  int
+	FirstFailedAt int64
+	LastFailedAt  int64
+	// NextRetryAt is zero for failures that are not retried until the
+	// file changes.
+	NextRetryAt int64
+	// Force carries a forced reupload over to its retries.
+	Force bool
+}
+
+// SkipReasonBaseline marks files that already existed when a watch was
+// first scanned in baseline mode.
+const SkipReasonBaseline = "baseline_existing"
+
+// Tombstone records an uploaded file that disappeared locally, and when its
+// server copy may be deleted.
+type Tombstone struct {
+	LocalPath   string
+	RemotePath  string
+	FileSize    int64
+	Mtime       int64
+	SHA256      *string
+	DeletedAt   int64
+	DeleteAfter int64
+}
+
+// DailyStats is one day of activity, keyed by the local date YYYY-MM-DD.
+type DailyStats struct {
+	Day            string `json:"day"`
+	FilesUploaded  int    `json:"files_uploaded"`
+	BytesUploaded  int64  `json:"bytes_uploaded"`
+	FilesSkipped   int    `json:"files_skipped"`
+	FilesFailed    int    `json:"files_failed"`
+	QueueHighWater int    `json:"queue_high_water"`
+}
+
+type PartRecord struct {
+	ETag   string
+	SHA256 string
 }
 
 func NewDB(dbPath string) (*DB, error) {
@@ -28,7 +92,9 @@ func NewDB(dbPath string) (*DB, error) {
 		return nil, err
 	}
 
-	db, err := sql.Open("sqlite", dbPath)
+	// The upload workers, the watcher and the tests all share this database,
+	// so wait on locks instead of failing with SQLITE_BUSY.
+	db, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)")
 	if err != nil {
 		return nil, err
 	}
@@ -41,6 +107,32 @@ func NewDB(dbPath string) (*DB, error) {
 	return &DB{db: db}, nil
 }
 
+// ErrNoDatabase is returned by OpenDBReadOnly when the database file does
+// not exist.
+var ErrNoDatabase = errors.New("no database")
+
+// OpenDBReadOnly opens an existing database for commands that only report
+// on it. Unlike NewDB it creates nothing and leaves the schema alone.
+func OpenDBReadOnly(dbPath string) (*DB, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w at %s", ErrNoDatabase, dbPath)
+		}
+		return nil, err
+	}
+
+	dsn := url.URL{Scheme: "file", Path: dbPath, RawQuery: "mode=ro&_pragma=busy_timeout(5000)"}
+	db, err := sql.Open("sqlite", dsn.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DB{db: db}, nil
+}
+
 func initSchema(db *sql.DB) error {
 	schema := `
 		CREATE TABLE IF NOT EXISTS files (
@@ -50,32 +142,127 @@ func initSchema(db *sql.DB) error {
 			file_size INTEGER NOT NULL,
 			mtime INTEGER NOT NULL,
 			uploaded_at INTEGER,
-			skip_reason TEXT
+			skip_reason TEXT,
+			sha256 TEXT,
+			locally_deleted INTEGER NOT NULL DEFAULT 0
 		);
 		CREATE INDEX IF NOT EXISTS idx_files_local_path ON files(local_path);
+		CREATE TABLE IF NOT EXISTS multipart_uploads (
+			local_path TEXT PRIMARY KEY,
+			remote_path TEXT NOT NULL,
+			upload_id TEXT NOT NULL,
+			file_size INTEGER NOT NULL,
+			mtime INTEGER NOT NULL,
+			chunk_size INTEGER NOT NULL,
+			created_at INTEGER NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS multipart_parts (
+			local_path TEXT NOT NULL,
+			part_number INTEGER NOT NULL,
+			etag TEXT NOT NULL,
+			sha256 TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (local_path, part_number)
+		);
+		CREATE TABLE IF NOT EXISTS failed_uploads (
+			local_path TEXT PRIMARY KEY,
+			remote_path TEXT NOT NULL,
+			error TEXT NOT NULL,
+			attempt_count INTEGER NOT NULL,
+			first_failed_at INTEGER NOT NULL,
+			last_failed_at INTEGER NOT NULL,
+			next_retry_at INTEGER NOT NULL,
+			force INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE INDEX IF NOT EXISTS idx_failed_uploads_next_retry_at ON failed_uploads(next_retry_at);
+		CREATE TABLE IF NOT EXISTS queue (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			local_path TEXT UNIQUE NOT NULL,
+			remote_path TEXT NOT NULL,
+			attempt_count INTEGER NOT NULL DEFAULT 0,
+			next_eligible_at INTEGER NOT NULL DEFAULT 0,
+			force INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE TABLE IF NOT EXISTS tombstones (
+			local_path TEXT PRIMARY KEY,
+			remote_path TEXT NOT NULL,
+			file_size INTEGER NOT NULL,
+			mtime INTEGER NOT NULL,
+			sha256 TEXT,
+			deleted_at INTEGER NOT NULL,
+			delete_after INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_tombstones_delete_after ON tombstones(delete_after);
+		CREATE TABLE IF NOT EXISTS baselines (
+			local_path TEXT PRIMARY KEY,
+			created_at INTEGER NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS watch_first_seen (
+			local_path TEXT PRIMARY KEY,
+			seen_at INTEGER NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS daily_stats (
+			day TEXT PRIMARY KEY,
+			files_uploaded INTEGER NOT NULL DEFAULT 0,
+			bytes_uploaded INTEGER NOT NULL DEFAULT 0,
+			files_skipped INTEGER NOT NULL DEFAULT 0,
+			files_failed INTEGER NOT NULL DEFAULT 0,
+			queue_high_water INTEGER NOT NULL DEFAULT 0,
+			reported_at INTEGER
+		);
+		CREATE TABLE IF NOT EXISTS daily_stats_files (
+			day TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			local_path TEXT NOT NULL,
+			PRIMARY KEY (day, kind, local_path)
+		);
 	`
-	_, err := db.Exec(schema)
-	return err
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+	if err := dbschema.AddColumnIfMissing(db, "files", "sha256", "TEXT"); err != nil {
+		return err
+	}
+	if err := dbschema.AddColumnIfMissing(db, "files", "locally_deleted", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := dbschema.AddColumnIfMissing(db, "multipart_parts", "sha256", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := dbschema.AddColumnIfMissing(db, "queue", "force", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	return dbschema.AddColumnIfMissing(db, "failed_uploads", "force", "INTEGER NOT NULL DEFAULT 0")
 }
 
-func (d *DB) GetFile(localPath string) (*FileRecord, error) {
-	row := d.db.QueryRow(`
-		SELECT id, local_path, remote_path, file_size, mtime, uploaded_at, skip_reason
-		FROM files WHERE local_path = ?
-	`, localPath)
+const fileColumns = `id, local_path, remote_path, file_size, mtime, uploaded_at, skip_reason, sha256, locally_deleted`
 
-	var rec FileRecord
-	err := row.Scan(&rec.ID, &rec.LocalPath, &rec.RemotePath, &rec.FileSize, &rec.Mtime, &rec.UploadedAt, &rec.SkipReason)
-	if err == sql.ErrNoRows {
-		return nil, nil
+func scanFileRecords(rows *sql.Rows) ([]FileRecord, error) {
+	defer rows.Close()
+
+	var recs []FileRecord
+	for rows.Next() {
+		var rec FileRecord
+		if err := rows.Scan(&rec.ID, &rec.LocalPath, &rec.RemotePath, &rec.FileSize, &rec.Mtime, &rec.UploadedAt, &rec.SkipReason, &rec.SHA256, &rec.LocallyDeleted); err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
 	}
+	return recs, rows.Err()
+}
+
+func (d *DB) GetFile(localPath string) (*FileRecord, error) {
+	rows, err := d.db.Query(`SELECT `+fileColumns+` FROM files WHERE local_path = ?`, localPath)
 	if err != nil {
 		return nil, err
 	}
-	return &rec, nil
+	recs, err := scanFileRecords(rows)
+	if err != nil || len(recs) == 0 {
+		return nil, err
+	}
+	return &recs[0], nil
 }
 
-func (d *DB) InsertFile(localPath, remotePath string, fileSize, mtime int64, skipReason *string) error {
+func (d *DB) InsertFile(localPath, remotePath string, fileSize, mtime int64, sha256, skipReason *string) error {
 	var uploadedAt *int64
 	if skipReason == nil {
 		now := time.Now().UTC().Unix()
@@ -83,13 +270,13 @@ func (d *DB) InsertFile(localPath, remotePath string, fileSize, mtime int64, ski
 	}
 
 	_, err := d.db.Exec(`
-		INSERT INTO files (local_path, remote_path, file_size, mtime, uploaded_at, skip_reason)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, localPath, remotePath, fileSize, mtime, uploadedAt, skipReason)
+		INSERT INTO files (local_path, remote_path, file_size, mtime, uploaded_at, skip_reason, sha256)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, localPath, remotePath, fileSize, mtime, uploadedAt, skipReason, sha256)
 	return err
 }
 
-func (d *DB) UpdateFile(localPath, remotePath string, fileSize, mtime int64, skipReason *string) error {
+func (d *DB) UpdateFile(localPath, remotePath string, fileSize, mtime int64, sha256, skipReason *string) error {
 	var uploadedAt *int64
 	if skipReason == nil {
 		now := time.Now().UTC().Unix()
@@ -97,9 +284,555 @@ func (d *DB) UpdateFile(localPath, remotePath string, fileSize, mtime int64, ski
 	}
 
 	_, err := d.db.Exec(`
-		UPDATE files SET remote_path = ?, file_size = ?, mtime = ?, uploaded_at = ?, skip_reason = ?
+		UPDATE files SET remote_path = ?, file_size = ?, mtime = ?, uploaded_at = ?, skip_reason = ?, sha256 = ?, locally_deleted = 0
 		WHERE local_path = ?
-	`, remotePath, fileSize, mtime, uploadedAt, skipReason, localPath)
+	`, remotePath, fileSize, mtime, uploadedAt, skipReason, sha256, localPath)
+	return err
+}
+
+// UpdateMtime records a new mtime for a file whose content is unchanged.
+func (d *DB) UpdateMtime(localPath string, mtime int64) error {
+	_, err := d.db.Exec(`UPDATE files SET mtime = ? WHERE local_path = ?`, mtime, localPath)
+	return err
+}
+
+// ListFilesUnder returns the files recorded below the directory dir.
+func (d *DB) ListFilesUnder(dir string) ([]FileRecord, error) {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	rows, err := d.db.Query(`
+		SELECT `+fileColumns+` FROM files WHERE local_path >= ? AND local_path < ?
+	`, prefix, dbschema.PrefixEnd(prefix))
+	if err != nil {
+		return nil, err
+	}
+	return scanFileRecords(rows)
+}
+
+// CleanupCandidates returns uploaded files that are still on disk and were
+// last uploaded at or before uploadedBefore.
+func (d *DB) CleanupCandidates(uploadedBefore int64) ([]FileRecord, error) {
+	rows, err := d.db.Query(`
+		SELECT `+fileColumns+` FROM files
+		WHERE skip_reason IS NULL AND locally_deleted = 0 AND uploaded_at <= ?
+		ORDER BY uploaded_at
+	`, uploadedBefore)
+	if err != nil {
+		return nil, err
+	}
+	return scanFileRecords(rows)
+}
+
+// SetLocallyDeleted marks a file that auto-cleanup removed from disk, so its
+// disappearance is not treated as a deletion to mirror.
+func (d *DB) SetLocallyDeleted(localPath string, deleted bool) error {
+	_, err := d.db.Exec(`UPDATE files SET locally_deleted = ? WHERE local_path = ?`, deleted, localPath)
+	return err
+}
+
+// UploadStats returns how many uploaded files are recorded below dir, their
+// total size, and the most recent upload time (nil if none). An empty dir
+// covers every file.
+func (d *DB) UploadStats(dir string) (count int, bytes int64, lastUploadedAt *int64, err error) {
+	prefix := ""
+	if dir != "" {
+		prefix = strings.TrimSuffix(dir, "/") + "/"
+	}
+	err = d.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(file_size), 0), MAX(uploaded_at) FROM files
+		WHERE skip_reason IS NULL AND local_path >= ? AND local_path < ?
+	`, prefix, dbschema.PrefixEnd(prefix)).Scan(&count, &bytes, &lastUploadedAt)
+	return count, bytes, lastUploadedAt, err
+}
+
+// SkipCounts returns the number of skipped files for each skip reason.
+func (d *DB) SkipCounts() (map[string]int, error) {
+	rows, err := d.db.Query(`
+		SELECT skip_reason, COUNT(*) FROM files
+		WHERE skip_reason IS NOT NULL GROUP BY skip_reason
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var reason string
+		var n int
+		if err := rows.Scan(&reason, &n); err != nil {
+			return nil, err
+		}
+		counts[reason] = n
+	}
+	return counts, rows.Err()
+}
+
+// IsBaselined reports whether the watch rooted at watchPath has had its
+// existing files recorded by a baseline scan.
+func (d *DB) IsBaselined(watchPath string) (bool, error) {
+	var n int
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM baselines WHERE local_path = ?`, watchPath).Scan(&n)
+	return n > 0, err
+}
+
+// WatchFirstSeen returns the unix time the daemon first started with the
+// watch rooted at watchPath, or 0 if it never has.
+func (d *DB) WatchFirstSeen(watchPath string) (int64, error) {
+	var seenAt int64
+	err := d.db.QueryRow(`SELECT seen_at FROM watch_first_seen WHERE local_path = ?`, watchPath).Scan(&seenAt)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return seenAt, err
+}
+
+// SetWatchFirstSeen records when a watch was first seen. An existing time
+// is kept.
+func (d *DB) SetWatchFirstSeen(watchPath string, seenAt int64) error {
+	_, err := d.db.Exec(`
+		INSERT OR IGNORE INTO watch_first_seen (local_path, seen_at) VALUES (?, ?)
+	`, watchPath, seenAt)
+	return err
+}
+
+// InsertBaseline records files that existed before a watch was first seen,
+// with skip_reason baseline_existing, and marks the watch as baselined.
+// Files that already have a row are left alone; the others are added to
+// today's files_skipped count.
+func (d *DB) InsertBaseline(watchPath string, recs []FileRecord) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT OR IGNORE INTO files (local_path, remote_path, file_size, mtime, skip_reason)
+		VALUES (?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var skipped int64
+	for _, rec := range recs {
+		res, err := stmt.Exec(rec.LocalPath, rec.RemotePath, rec.FileSize, rec.Mtime, SkipReasonBaseline)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		skipped += n
+	}
+	if skipped > 0 {
+		if _, err := tx.Exec(`
+			INSERT INTO daily_stats (day, files_skipped) VALUES (?, ?)
+			ON CONFLICT(day) DO UPDATE SET files_skipped = files_skipped + excluded.files_skipped
+		`, statsDay(time.Now()), skipped); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`
+		INSERT OR REPLACE INTO baselines (local_path, created_at) VALUES (?, ?)
+	`, watchPath, time.Now().UTC().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *DB) GetMultipartUpload(localPath string) (*MultipartRecord, error) {
+	row := d.db.QueryRow(`
+		SELECT local_path, remote_path, upload_id, file_size, mtime, chunk_size
+		FROM multipart_uploads WHERE local_path = ?
+	`, localPath)
+
+	var rec MultipartRecord
+	err := row.Scan(&rec.LocalPath, &rec.RemotePath, &rec.UploadID, &rec.FileSize, &rec.Mtime, &rec.ChunkSize)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.Query(`
+		SELECT part_number, etag, sha256 FROM multipart_parts WHERE local_path = ?
+	`, localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rec.Parts = make(map[int32]PartRecord)
+	for rows.Next() {
+		var partNumber int32
+		var part PartRecord
+		if err := rows.Scan(&partNumber, &part.ETag, &part.SHA256); err != nil {
+			return nil, err
+		}
+		rec.Parts[partNumber] = part
+	}
+	return &rec, rows.Err()
+}
+
+func (d *DB) ListMultipartUploads() ([]MultipartRecord, error) {
+	rows, err := d.db.Query(`
+		SELECT local_path, remote_path, upload_id, file_size, mtime, chunk_size
+		FROM multipart_uploads ORDER BY created_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recs []MultipartRecord
+	for rows.Next() {
+		var rec MultipartRecord
+		if err := rows.Scan(&rec.LocalPath, &rec.RemotePath, &rec.UploadID, &rec.FileSize, &rec.Mtime, &rec.ChunkSize); err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+	return recs, rows.Err()
+}
+
+func (d *DB) InsertMultipartUpload(rec *MultipartRecord) error {
+	_, err := d.db.Exec(`
+		INSERT OR REPLACE INTO multipart_uploads (local_path, remote_path, upload_id, file_size, mtime, chunk_size, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, rec.LocalPath, rec.RemotePath, rec.UploadID, rec.FileSize, rec.Mtime, rec.ChunkSize, time.Now().UTC().Unix())
+	return err
+}
+
+func (d *DB) InsertMultipartPart(localPath string, partNumber int32, part PartRecord) error {
+	_, err := d.db.Exec(`
+		INSERT OR REPLACE INTO multipart_parts (local_path, part_number, etag, sha256)
+		VALUES (?, ?, ?, ?)
+	`, localPath, partNumber, part.ETag, part.SHA256)
+	return err
+}
+
+func (d *DB) DeleteMultipartUpload(localPath string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM multipart_parts WHERE local_path = ?`, localPath); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM multipart_uploads WHERE local_path = ?`, localPath); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *DB) LoadQueue() ([]QueueEntry, error) {
+	rows, err := d.db.Query(`
+		SELECT local_path, remote_path, attempt_count, next_eligible_at, force
+		FROM queue ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []QueueEntry
+	for rows.Next() {
+		var entry QueueEntry
+		var nextEligibleAt int64
+		if err := rows.Scan(&entry.LocalPath, &entry.RemotePath, &entry.AttemptCount, &nextEligibleAt, &entry.Force); err != nil {
+			return nil, err
+		}
+		if nextEligibleAt > 0 {
+			entry.NotBefore = time.Unix(nextEligibleAt, 0)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// QueueLen returns the number of entries in the persisted upload queue.
+func (d *DB) QueueLen() (int, error) {
+	var n int
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM queue`).Scan(&n)
+	return n, err
+}
+
+func (d *DB) SaveQueueEntry(entry QueueEntry) error {
+	var nextEligibleAt int64
+	if !entry.NotBefore.IsZero() {
+		nextEligibleAt = entry.NotBefore.UTC().Unix()
+	}
+	_, err := d.db.Exec(`
+		INSERT OR REPLACE INTO queue (local_path, remote_path, attempt_count, next_eligible_at, force)
+		VALUES (?, ?, ?, ?, ?)
+	`, entry.LocalPath, entry.RemotePath, entry.AttemptCount, nextEligibleAt, entry.Force)
+	return err
+}
+
+func (d *DB) DeleteQueueEntry(localPath string) error {
+	_, err := d.db.Exec(`DELETE FROM queue WHERE local_path = ?`, localPath)
+	return err
+}
+
+const failedUploadColumns = `local_path, remote_path, error, attempt_count, first_failed_at, last_failed_at, next_retry_at, force`
+
+func scanFailedUploads(rows *sql.Rows) ([]FailedUpload, error) {
+	defer rows.Close()
+
+	var failed []FailedUpload
+	for rows.Next() {
+		var f FailedUpload
+		if err := rows.Scan(&f.LocalPath, &f.RemotePath, &f.Error, &f.AttemptCount, &f.FirstFailedAt, &f.LastFailedAt, &f.NextRetryAt, &f.Force); err != nil {
+			return nil, err
+		}
+		failed = append(failed, f)
+	}
+	return failed, rows.Err()
+}
+
+func (d *DB) GetFailedUpload(localPath string) (*FailedUpload, error) {
+	rows, err := d.db.Query(`SELECT `+failedUploadColumns+` FROM failed_uploads WHERE local_path = ?`, localPath)
+	if err != nil {
+		return nil, err
+	}
+	failed, err := scanFailedUploads(rows)
+	if err != nil || len(failed) == 0 {
+		return nil, err
+	}
+	return &failed[0], nil
+}
+
+func (d *DB) SaveFailedUpload(f *FailedUpload) error {
+	_, err := d.db.Exec(`
+		INSERT OR REPLACE INTO failed_uploads (`+failedUploadColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, f.LocalPath, f.RemotePath, f.Error, f.AttemptCount, f.FirstFailedAt, f.LastFailedAt, f.NextRetryAt, f.Force)
+	return err
+}
+
+func (d *DB) SetFailedRetryAt(localPath string, nextRetryAt int64) error {
+	_, err := d.db.Exec(`UPDATE failed_uploads SET next_retry_at = ? WHERE local_path = ?`, nextRetryAt, localPath)
+	return err
+}
+
+func (d *DB) DeleteFailedUpload(localPath string) error {
+	_, err := d.db.Exec(`DELETE FROM failed_uploads WHERE local_path = ?`, localPath)
+	return err
+}
+
+// DueFailedUploads returns failed uploads whose next retry time has passed.
+// Failures with no retry time (zero) are never due.
+func (d *DB) DueFailedUploads(now int64) ([]FailedUpload, error) {
+	rows, err := d.db.Query(`
+		SELECT `+failedUploadColumns+` FROM failed_uploads
+		WHERE next_retry_at > 0 AND next_retry_at <= ? ORDER BY next_retry_at
+	`, now)
+	if err != nil {
+		return nil, err
+	}
+	return scanFailedUploads(rows)
+}
+
+// ListFailedUploads returns all failed uploads, most recent failure first.
+func (d *DB) ListFailedUploads() ([]FailedUpload, error) {
+	rows, err := d.db.Query(`SELECT ` + failedUploadColumns + ` FROM failed_uploads ORDER BY last_failed_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	return scanFailedUploads(rows)
+}
+
+const tombstoneColumns = `local_path, remote_path, file_size, mtime, sha256, deleted_at, delete_after`
+
+func scanTombstones(rows *sql.Rows) ([]Tombstone, error) {
+	defer rows.Close()
+
+	var tombstones []Tombstone
+	for rows.Next() {
+		var t Tombstone
+		if err := rows.Scan(&t.LocalPath, &t.RemotePath, &t.FileSize, &t.Mtime, &t.SHA256, &t.DeletedAt, &t.DeleteAfter); err != nil {
+			return nil, err
+		}
+		tombstones = append(tombstones, t)
+	}
+	return tombstones, rows.Err()
+}
+
+// SaveTombstone records a deleted file. An existing tombstone for the same
+// path is kept, so repeated events do not extend the grace period.
+func (d *DB) SaveTombstone(t *Tombstone) error {
+	_, err := d.db.Exec(`
+		INSERT OR IGNORE INTO tombstones (`+tombstoneColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, t.LocalPath, t.RemotePath, t.FileSize, t.Mtime, t.SHA256, t.DeletedAt, t.DeleteAfter)
+	return err
+}
+
+func (d *DB) DeleteTombstone(localPath string) error {
+	_, err := d.db.Exec(`DELETE FROM tombstones WHERE local_path = ?`, localPath)
+	return err
+}
+
+// ExpireTombstone makes a tombstone due immediately, once its file is known
+// to have been renamed rather than deleted.
+func (d *DB) ExpireTombstone(localPath string, now int64) error {
+	_, err := d.db.Exec(`UPDATE tombstones SET delete_after = ? WHERE local_path = ?`, now, localPath)
+	return err
+}
+
+// DueTombstones returns tombstones whose grace period has passed.
+func (d *DB) DueTombstones(now int64) ([]Tombstone, error) {
+	rows, err := d.db.Query(`
+		SELECT `+tombstoneColumns+` FROM tombstones
+		WHERE delete_after <= ? ORDER BY delete_after
+	`, now)
+	if err != nil {
+		return nil, err
+	}
+	return scanTombstones(rows)
+}
+
+// RenameCandidates returns pending tombstones for files with the given size
+// and mtime, which a rename preserves.
+func (d *DB) RenameCandidates(fileSize, mtime int64) ([]Tombstone, error) {
+	rows, err := d.db.Query(`
+		SELECT `+tombstoneColumns+` FROM tombstones
+		WHERE file_size = ? AND mtime = ? ORDER BY deleted_at DESC
+	`, fileSize, mtime)
+	if err != nil {
+		return nil, err
+	}
+	return scanTombstones(rows)
+}
+
+// ForgetDeletedFile drops the files row and tombstone of a file whose
+// server copy has been deleted.
+func (d *DB) ForgetDeletedFile(localPath string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM files WHERE local_path = ?`, localPath); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM tombstones WHERE local_path = ?`, localPath); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddDailyStats adds the counters in delta to the row for delta.Day and
+// raises its queue high-water mark to delta.QueueHighWater.
+func (d *DB) AddDailyStats(delta DailyStats) error {
+	_, err := d.db.Exec(`
+		INSERT INTO daily_stats (day, files_uploaded, bytes_uploaded, files_skipped, files_failed, queue_high_water)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(day) DO UPDATE SET
+			files_uploaded = files_uploaded + excluded.files_uploaded,
+			bytes_uploaded = bytes_uploaded + excluded.bytes_uploaded,
+			files_skipped = files_skipped + excluded.files_skipped,
+			files_failed = files_failed + excluded.files_failed,
+			queue_high_water = MAX(queue_high_water, excluded.queue_high_water)
+	`, delta.Day, delta.FilesUploaded, delta.BytesUploaded, delta.FilesSkipped, delta.FilesFailed, delta.QueueHighWater)
+	return err
+}
+
+// Kinds of per-file daily counts, see CountDailyFile.
+const (
+	DailyFileSkipped = "skipped"
+	DailyFileFailed  = "failed"
+)
+
+// CountDailyFile adds localPath to the day's files_skipped or files_failed
+// count, unless it was already counted under kind that day. Entries of
+// earlier days are dropped, since only today's can still be counted.
+func (d *DB) CountDailyFile(day, kind, localPath string) error {
+	var column string
+	switch kind {
+	case DailyFileSkipped:
+		column = "files_skipped"
+	case DailyFileFailed:
+		column = "files_failed"
+	default:
+		return fmt.Errorf("unknown daily file count %q", kind)
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM daily_stats_files WHERE day < ?`, day); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`
+		INSERT OR IGNORE INTO daily_stats_files (day, kind, local_path) VALUES (?, ?, ?)
+	`, day, kind, localPath)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO daily_stats (day, `+column+`) VALUES (?, 1)
+		ON CONFLICT(day) DO UPDATE SET `+column+` = `+column+` + 1
+	`, day); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *DB) GetDailyStats(day string) (*DailyStats, error) {
+	var s DailyStats
+	err := d.db.QueryRow(`
+		SELECT day, files_uploaded, bytes_uploaded, files_skipped, files_failed, queue_high_water
+		FROM daily_stats WHERE day = ?
+	`, day).Scan(&s.Day, &s.FilesUploaded, &s.BytesUploaded, &s.FilesSkipped, &s.FilesFailed, &s.QueueHighWater)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// UnreportedDailyStats returns the days before the given day that have not
+// been accepted by the server yet, oldest first.
+func (d *DB) UnreportedDailyStats(before string) ([]DailyStats, error) {
+	rows, err := d.db.Query(`
+		SELECT day, files_uploaded, bytes_uploaded, files_skipped, files_failed, queue_high_water
+		FROM daily_stats WHERE day < ? AND reported_at IS NULL ORDER BY day
+	`, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []DailyStats
+	for rows.Next() {
+		var s DailyStats
+		if err := rows.Scan(&s.Day, &s.FilesUploaded, &s.BytesUploaded, &s.FilesSkipped, &s.FilesFailed, &s.QueueHighWater); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+func (d *DB) MarkDailyStatsReported(day string, at int64) error {
+	_, err := d.db.Exec(`UPDATE daily_stats SET reported_at = ? WHERE day = ?`, at, day)
 	return err
 }
 
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (u *Uploader) Delete(remotePath string) error {
+	form := url.Values{"path": {remotePath}}
+	req, err := u.newRequest("POST", "/delete", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var result struct{}
+	return u.doJSON("delete", req, &result)
+}
+
+// Copy asks the server to copy an uploaded object to a new path.
+func (u *Uploader) Copy(from, to string, size int64, checksum string) (*UploadResponse, error) {
+	form := url.Values{
+		"from":   {from},
+		"to":     {to},
+		"size":   {strconv.FormatInt(size, 10)},
+		"sha256": {checksum},
+	}
+	req, err := u.newRequest("POST", "/copy", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var result UploadResponse
+	if err := u.doJSON("copy", req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// TombstoneSweeper deletes the server copies of files that were removed
+// from a mirror_deletes watch once their grace period has passed.
+type TombstoneSweeper struct {
+	db       *DB
+	uploader *Uploader
+	cfg      *Config
+	interval time.Duration
+}
+
+func NewTombstoneSweeper(db *DB, uploader *Uploader, cfg *Config) *TombstoneSweeper {
+	interval := 30 * time.Second
+	for _, w := range cfg.Watches {
+		grace := time.Duration(w.DeleteGraceSeconds) * time.Second
+		if w.MirrorDeletes && grace < interval {
+			interval = grace
+		}
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	return &TombstoneSweeper{
+		db:       db,
+		uploader: uploader,
+		cfg:      cfg,
+		interval: interval,
+	}
+}
+
+func (s *TombstoneSweeper) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := s.SweepDue(); err != nil {
+				log.Printf("failed to mirror deletions: %v", err)
+			}
+		}
+	}
+}
+
+// SweepDue deletes the server copy of every tombstoned file whose grace
+// period has passed and returns how many were deleted. Files that came back
+// and watches that no longer mirror deletes only lose their tombstone.
+func (s *TombstoneSweeper) SweepDue() (int, error) {
+	due, err := s.db.DueTombstones(time.Now().UTC().Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, t := range due {
+		watch := s.cfg.WatchFor(t.LocalPath)
+		_, statErr := os.Lstat(t.LocalPath)
+		if watch == nil || !watch.MirrorDeletes || statErr == nil {
+			if err := s.db.DeleteTombstone(t.LocalPath); err != nil {
+				return deleted, err
+			}
+			continue
+		}
+
+		if err := s.uploader.Delete(t.RemotePath); err != nil {
+			log.Printf("failed to delete %s from server: %v", t.RemotePath, err)
+			continue
+		}
+		if err := s.db.ForgetDeletedFile(t.LocalPath); err != nil {
+			return deleted, err
+		}
+		log.Printf("deleted %s from server (removed locally)", t.RemotePath)
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,275 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type completedPart struct {
+	PartNumber int32  `json:"part_number"`
+	ETag       string `json:"etag"`
+	SHA256     string `json:"sha256"`
+}
+
+type multipartCompleteRequest struct {
+	Path     string          `json:"path"`
+	UploadID string          `json:"upload_id"`
+	Size     int64           `json:"size"`
+	SHA256   string          `json:"sha256"`
+	Parts    []completedPart `json:"parts"`
+}
+
+func (r *MultipartRecord) matches(remotePath string, size, mtime, chunkSize int64) bool {
+	return r.RemotePath == remotePath && r.FileSize == size && r.Mtime == mtime && r.ChunkSize == chunkSize
+}
+
+func (r *MultipartRecord) numParts() int32 {
+	return int32((r.FileSize + r.ChunkSize - 1) / r.ChunkSize)
+}
+
+// partRange returns where part partNumber (1-based) lies in the file.
+func (r *MultipartRecord) partRange(partNumber int32) (offset, length int64) {
+	offset = int64(partNumber-1) * r.ChunkSize
+	length = r.ChunkSize
+	if offset+length > r.FileSize {
+		length = r.FileSize - offset
+	}
+	return offset, length
+}
+
+func isUploadNotFound(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
+// uploadMultipart sends the file in chunks, recording every finished part so
+// that an interrupted upload picks up where it left off.
+func (u *Uploader) uploadMultipart(file *os.File, info os.FileInfo, localPath, remotePath string) (*UploadResponse, error) {
+	size := info.Size()
+	mtime := info.ModTime().UTC().Unix()
+
+	rec, err := u.db.GetMultipartUpload(localPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if rec != nil && !rec.matches(remotePath, size, mtime, u.chunkSize) {
+		u.abortMultipart(rec)
+		rec = nil
+	}
+
+	if rec == nil {
+		uploadID, err := u.initMultipart(remotePath)
+		if err != nil {
+			return nil, err
+		}
+		rec = &MultipartRecord{
+			LocalPath:  localPath,
+			RemotePath: remotePath,
+			UploadID:   uploadID,
+			FileSize:   size,
+			Mtime:      mtime,
+			ChunkSize:  u.chunkSize,
+			Parts:      make(map[int32]PartRecord),
+		}
+		if err := u.db.InsertMultipartUpload(rec); err != nil {
+			return nil, err
+		}
+	} else if len(rec.Parts) > 0 {
+		log.Printf("resuming upload of %s with %d parts already sent", localPath, len(rec.Parts))
+	}
+
+	resp, err := u.sendParts(file, rec)
+	if isUploadNotFound(err) {
+		u.db.DeleteMultipartUpload(localPath)
+	}
+	return resp, err
+}
+
+func (u *Uploader) sendParts(file *os.File, rec *MultipartRecord) (*UploadResponse, error) {
+	numParts := rec.numParts()
+
+	for partNumber := int32(1); partNumber <= numParts; partNumber++ {
+		if _, done := rec.Parts[partNumber]; done {
+			continue
+		}
+
+		offset, length := rec.partRange(partNumber)
+		checksum, err := hashSection(file, offset, length)
+		if err != nil {
+			return nil, err
+		}
+
+		body := newVerifyingReader(io.NewSectionReader(file, offset, length), checksum)
+		etag, err := u.uploadPart(rec, partNumber, body, length, checksum)
+		if err != nil {
+			return nil, fmt.Errorf("part %d/%d of %s: %w", partNumber, numParts, rec.LocalPath, err)
+		}
+
+		part := PartRecord{ETag: etag, SHA256: checksum}
+		if err := u.db.InsertMultipartPart(rec.LocalPath, partNumber, part); err != nil {
+			return nil, err
+		}
+		rec.Parts[partNumber] = part
+	}
+
+	checksum, err := hashParts(file, rec)
+	if errors.Is(err, ErrChecksumMismatch) {
+		// The parts sent no longer describe the file; start over.
+		u.abortMultipart(rec)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := u.completeMultipart(rec, checksum)
+	if err != nil {
+		return nil, err
+	}
+	result.SHA256 = checksum
+
+	if err := u.db.DeleteMultipartUpload(rec.LocalPath); err != nil {
+		log.Printf("failed to clear multipart state for %s: %v", rec.LocalPath, err)
+	}
+	return result, nil
+}
+
+// hashParts computes the file's SHA-256 in one pass that also hashes every
+// part again, so the whole-file digest the server records describes the
+// same bytes as the part digests it verified. A part that no longer matches
+// means the file changed after it was sent.
+func hashParts(file io.ReaderAt, rec *MultipartRecord) (string, error) {
+	whole := sha256.New()
+	for partNumber := int32(1); partNumber <= rec.numParts(); partNumber++ {
+		offset, length := rec.partRange(partNumber)
+		part := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(whole, part), io.NewSectionReader(file, offset, length)); err != nil {
+			return "", err
+		}
+		if hex.EncodeToString(part.Sum(nil)) != rec.Parts[partNumber].SHA256 {
+			return "", fmt.Errorf("part %d of %s changed after it was sent: %w", partNumber, rec.LocalPath, ErrChecksumMismatch)
+		}
+	}
+	return hex.EncodeToString(whole.Sum(nil)), nil
+}
+
+func (u *Uploader) initMultipart(remotePath string) (string, error) {
+	form := url.Values{"path": {remotePath}}
+	req, err := u.newRequest("POST", "/multipart/init", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var result struct {
+		UploadID string `json:"upload_id"`
+	}
+	if err := u.doJSON("multipart init", req, &result); err != nil {
+		return "", err
+	}
+	return result.UploadID, nil
+}
+
+func (u *Uploader) uploadPart(rec *MultipartRecord, partNumber int32, body io.Reader, length int64, checksum string) (string, error) {
+	query := url.Values{
+		"path":        {rec.RemotePath},
+		"upload_id":   {rec.UploadID},
+		"part_number": {strconv.Itoa(int(partNumber))},
+		"sha256":      {checksum},
+	}
+	req, err := u.newRequest("POST", "/multipart/part?"+query.Encode(), body)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = length
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	var result struct {
+		ETag string `json:"etag"`
+	}
+	if err := u.doJSON("part upload", req, &result); err != nil {
+		return "", err
+	}
+	return result.ETag, nil
+}
+
+func (u *Uploader) completeMultipart(rec *MultipartRecord, checksum string) (*UploadResponse, error) {
+	parts := make([]completedPart, 0, len(rec.Parts))
+	for partNumber, part := range rec.Parts {
+		parts = append(parts, completedPart{PartNumber: partNumber, ETag: part.ETag, SHA256: part.SHA256})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+
+	body, err := json.Marshal(multipartCompleteRequest{
+		Path:     rec.RemotePath,
+		UploadID: rec.UploadID,
+		Size:     rec.FileSize,
+		SHA256:   checksum,
+		Parts:    parts,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := u.newRequest("POST", "/multipart/complete", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var result UploadResponse
+	if err := u.doJSON("multipart complete", req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// abortMultipart discards an upload on the server and forgets it locally.
+// Failures are only logged; S3 lifecycle rules clean up whatever is left.
+func (u *Uploader) abortMultipart(rec *MultipartRecord) {
+	form := url.Values{"path": {rec.RemotePath}, "upload_id": {rec.UploadID}}
+	req, err := u.newRequest("POST", "/multipart/abort", strings.NewReader(form.Encode()))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		var result struct{}
+		err = u.doJSON("multipart abort", req, &result)
+	}
+	if err != nil && !isUploadNotFound(err) {
+		log.Printf("failed to abort multipart upload for %s: %v", rec.LocalPath, err)
+	}
+
+	if err := u.db.DeleteMultipartUpload(rec.LocalPath); err != nil {
+		log.Printf("failed to clear multipart state for %s: %v", rec.LocalPath, err)
+	}
+}
+
+// ResumePending re-queues files whose multipart upload was interrupted, and
+// aborts uploads whose local file has since disappeared.
+func (u *Uploader) ResumePending(queue *Queue) error {
+	recs, err := u.db.ListMultipartUploads()
+	if err != nil {
+		return err
+	}
+
+	for i := range recs {
+		rec := &recs[i]
+		if _, err := os.Stat(rec.LocalPath); os.IsNotExist(err) {
+			u.abortMultipart(rec)
+			continue
+		}
+		queue.Enqueue(rec.LocalPath, rec.RemotePath)
+	}
+	return nil
+}
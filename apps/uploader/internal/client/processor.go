@@ -1,51 +1,93 @@
 package client
 
 import (
+	"errors"
 	"log"
+	"net/http"
 	"os"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-const maxTrackedFailures = 10
-
 type Processor struct {
 	queue        *Queue
 	db           *DB
 	uploader     *Uploader
 	cfg          *Config
 	maxSizeBytes int64
-	debounce     time.Duration
+	workers      int
+	stability    *StabilityTracker
+	breaker      *CircuitBreaker
 
-	failedMu    sync.Mutex
-	failedFiles []string
-	stopping    atomic.Bool
+	stopping atomic.Bool
+	paused   atomic.Bool
 }
 
 func NewProcessor(queue *Queue, db *DB, uploader *Uploader, cfg *Config) *Processor {
+	workers := cfg.Upload.Concurrent
+	if workers < 1 {
+		workers = 1
+	}
 	return &Processor{
 		queue:        queue,
 		db:           db,
 		uploader:     uploader,
 		cfg:          cfg,
 		maxSizeBytes: int64(cfg.Upload.MaxFileSizeMB) * 1024 * 1024,
-		debounce:     time.Duration(cfg.Stability.DebounceSeconds) * time.Second,
+		workers:      workers,
+		stability:    NewStabilityTracker(queue, time.Duration(cfg.Stability.DebounceSeconds)*time.Second, cfg.Stability.MaxAttempts),
+		breaker:      NewCircuitBreaker(uploader, cfg),
 	}
 }
 
+// Run processes the queue with upload.concurrent workers and returns once
+// all of them have stopped.
 func (p *Processor) Run(stop <-chan struct{}) {
+	trackerStop := make(chan struct{})
+	defer close(trackerStop)
+	go p.stability.Run(trackerStop)
+
+	var wg sync.WaitGroup
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.runWorker(stop)
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Processor) shouldStop(stop <-chan struct{}) bool {
+	if p.stopping.Load() {
+		return true
+	}
+	if stop != nil {
+		select {
+		case <-stop:
+			return true
+		default:
+		}
+	}
+	return false
+}
+
+func (p *Processor) runWorker(stop <-chan struct{}) {
 	for {
-		if p.stopping.Load() {
+		if p.shouldStop(stop) {
 			return
 		}
 
-		if stop != nil {
-			select {
-			case <-stop:
-				return
-			default:
-			}
+		if p.breaker.IsOpen() || p.paused.Load() {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		if entry, ok := p.stability.PopReady(); ok {
+			p.uploadEntry(entry, stop)
+			p.queue.Done(entry.LocalPath)
+			continue
 		}
 
 		entry, ok := p.queue.Dequeue()
@@ -54,7 +96,9 @@ func (p *Processor) Run(stop <-chan struct{}) {
 			continue
 		}
 
-		p.ProcessEntry(entry)
+		if !p.ProcessEntry(entry) {
+			p.queue.Done(entry.LocalPath)
+		}
 	}
 }
 
@@ -62,90 +106,342 @@ func (p *Processor) Stop() {
 	p.stopping.Store(true)
 }
 
-func (p *Processor) HasFailures() bool {
-	p.failedMu.Lock()
-	defer p.failedMu.Unlock()
-	return len(p.failedFiles) > 0
+// Pause stops workers from starting new uploads; uploads already running
+// finish. Files keep queueing while paused.
+func (p *Processor) Pause() {
+	p.paused.Store(true)
+}
+
+func (p *Processor) Resume() {
+	p.paused.Store(false)
 }
 
-func (p *Processor) FailedFiles() []string {
-	p.failedMu.Lock()
-	defer p.failedMu.Unlock()
-	result := make([]string, len(p.failedFiles))
-	copy(result, p.failedFiles)
-	return result
+func (p *Processor) Paused() bool {
+	return p.paused.Load()
 }
 
-func (p *Processor) recordFailure(path string) {
-	p.failedMu.Lock()
-	defer p.failedMu.Unlock()
-	if len(p.failedFiles) < maxTrackedFailures {
-		p.failedFiles = append(p.failedFiles, path)
+// recordFailure stores a failed upload so the RetryScheduler tries it again
+// after an exponentially growing delay. Errors that retrying cannot fix are
+// recorded without a retry time; the file is tried again when it changes.
+func (p *Processor) recordFailure(entry QueueEntry, uploadErr error) {
+	prev, err := p.db.GetFailedUpload(entry.LocalPath)
+	if err != nil {
+		log.Printf("db error for %s: %v", entry.LocalPath, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	f := &FailedUpload{
+		LocalPath:     entry.LocalPath,
+		RemotePath:    entry.RemotePath,
+		Error:         uploadErr.Error(),
+		AttemptCount:  1,
+		FirstFailedAt: now.Unix(),
+		LastFailedAt:  now.Unix(),
+		Force:         entry.Force,
+	}
+	if prev != nil {
+		f.AttemptCount = prev.AttemptCount + 1
+		f.FirstFailedAt = prev.FirstFailedAt
+	}
+
+	retryable := isRetryable(uploadErr)
+	delay := failedRetryDelay(f.AttemptCount,
+		time.Duration(p.cfg.Upload.FailedRetryInitialSeconds)*time.Second,
+		time.Duration(p.cfg.Upload.FailedRetryMaxSeconds)*time.Second)
+	if retryable {
+		f.NextRetryAt = now.Add(delay).Unix()
+	}
+
+	if err := p.db.SaveFailedUpload(f); err != nil {
+		log.Printf("failed to record failed upload %s: %v", entry.LocalPath, err)
+		return
+	}
+	p.countFile(DailyFileFailed, entry.LocalPath)
+	if !retryable {
+		log.Printf("not retrying %s until it changes (failure %d)", entry.LocalPath, f.AttemptCount)
+		return
 	}
+	log.Printf("will retry %s in %s (failure %d)", entry.LocalPath, delay, f.AttemptCount)
 }
 
-func (p *Processor) ProcessEntry(entry QueueEntry) {
+// clearFailure forgets a failed upload of a file that turned out not to
+// need uploading.
+func (p *Processor) clearFailure(localPath string) {
+	if err := p.db.DeleteFailedUpload(localPath); err != nil {
+		log.Printf("db error for %s: %v", localPath, err)
+	}
+}
+
+// isRetryable reports whether an upload error may succeed on another try.
+// Requests the server rejected as malformed or unauthorized will not, but a
+// checksum mismatch usually means the file changed mid-upload.
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrChecksumMismatch) {
+		return true
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return true
+		}
+		return statusErr.StatusCode < 400 || statusErr.StatusCode >= 500
+	}
+	return true
+}
+
+// ProcessEntry checks whether a dequeued file needs uploading and, if so,
+// hands it to the stability tracker. It reports whether the file is now
+// tracked, in which case its path stays in flight until the upload is done.
+func (p *Processor) ProcessEntry(entry QueueEntry) bool {
 	info, err := os.Stat(entry.LocalPath)
 	if err != nil {
-		return
+		if os.IsNotExist(err) {
+			p.recordDeletion(entry)
+		}
+		return false
 	}
 
 	rec, err := p.db.GetFile(entry.LocalPath)
 	if err != nil {
 		log.Printf("db error for %s: %v", entry.LocalPath, err)
-		return
+		return false
+	}
+
+	if rec != nil && rec.LocallyDeleted {
+		// The file was put back after auto-cleanup removed it.
+		if err := p.db.SetLocallyDeleted(entry.LocalPath, false); err != nil {
+			log.Printf("db error for %s: %v", entry.LocalPath, err)
+		}
 	}
 
 	currentMtime := info.ModTime().UTC().Unix()
-	if rec != nil && rec.Mtime == currentMtime {
-		return
+	if !entry.Force && rec != nil && rec.Mtime == currentMtime {
+		p.clearFailure(entry.LocalPath)
+		return false
+	}
+
+	if !entry.Force && p.contentUnchanged(entry.LocalPath, rec, info) {
+		if err := p.db.UpdateMtime(entry.LocalPath, currentMtime); err != nil {
+			log.Printf("db error for %s: %v", entry.LocalPath, err)
+		}
+		p.countFile(DailyFileSkipped, entry.LocalPath)
+		p.clearFailure(entry.LocalPath)
+		return false
 	}
 
 	if info.Size() > p.maxSizeBytes {
 		reason := "file_too_large"
 		if rec == nil {
-			p.db.InsertFile(entry.LocalPath, entry.RemotePath, info.Size(), currentMtime, &reason)
+			p.db.InsertFile(entry.LocalPath, entry.RemotePath, info.Size(), currentMtime, nil, &reason)
 		} else {
-			p.db.UpdateFile(entry.LocalPath, entry.RemotePath, info.Size(), currentMtime, &reason)
+			p.db.UpdateFile(entry.LocalPath, entry.RemotePath, info.Size(), currentMtime, nil, &reason)
 		}
 		log.Printf("skipped %s: file too large (%d bytes)", entry.LocalPath, info.Size())
-		return
+		p.countFile(DailyFileSkipped, entry.LocalPath)
+		p.clearFailure(entry.LocalPath)
+		return false
+	}
+
+	p.stability.Add(entry, info)
+	return true
+}
+
+// contentUnchanged reports whether a previously uploaded file whose mtime
+// changed still has the recorded content, for watches with skip_unchanged.
+func (p *Processor) contentUnchanged(localPath string, rec *FileRecord, info os.FileInfo) bool {
+	if rec == nil || rec.SkipReason != nil || rec.SHA256 == nil || rec.FileSize != info.Size() {
+		return false
+	}
+
+	watch := p.cfg.WatchFor(localPath)
+	if watch == nil || !watch.SkipUnchanged {
+		return false
 	}
 
-	time.Sleep(p.debounce)
+	file, err := os.Open(localPath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
 
-	info2, err := os.Stat(entry.LocalPath)
+	checksum, err := hashSection(file, 0, info.Size())
 	if err != nil {
+		return false
+	}
+	return checksum == *rec.SHA256
+}
+
+// recordDeletion tombstones the uploaded file at a path that disappeared
+// from a mirror_deletes watch, or every uploaded file below it if the path
+// was a directory. The TombstoneSweeper deletes them after the grace period.
+func (p *Processor) recordDeletion(entry QueueEntry) {
+	watch := p.cfg.WatchFor(entry.LocalPath)
+	if watch == nil || !watch.MirrorDeletes {
 		return
 	}
 
-	mtime2 := info2.ModTime().UTC().Unix()
-	if mtime2 != currentMtime || info2.Size() != info.Size() {
-		entry.AttemptCount++
-		if entry.AttemptCount >= p.cfg.Stability.MaxAttempts {
-			log.Printf("giving up on %s after %d stability attempts", entry.LocalPath, entry.AttemptCount)
-			return
+	recs, err := p.db.ListFilesUnder(entry.LocalPath)
+	if err != nil {
+		log.Printf("db error for %s: %v", entry.LocalPath, err)
+		return
+	}
+	rec, err := p.db.GetFile(entry.LocalPath)
+	if err != nil {
+		log.Printf("db error for %s: %v", entry.LocalPath, err)
+		return
+	}
+	if rec != nil {
+		recs = append(recs, *rec)
+	}
+
+	now := time.Now().UTC()
+	grace := time.Duration(watch.DeleteGraceSeconds) * time.Second
+	for _, rec := range recs {
+		// Auto-cleanup removed it on purpose; the server copy stays.
+		if rec.SkipReason != nil || rec.LocallyDeleted {
+			continue
+		}
+		err := p.db.SaveTombstone(&Tombstone{
+			LocalPath:   rec.LocalPath,
+			RemotePath:  rec.RemotePath,
+			FileSize:    rec.FileSize,
+			Mtime:       rec.Mtime,
+			SHA256:      rec.SHA256,
+			DeletedAt:   now.Unix(),
+			DeleteAfter: now.Add(grace).Unix(),
+		})
+		if err != nil {
+			log.Printf("failed to record deletion of %s: %v", rec.LocalPath, err)
+			continue
+		}
+		log.Printf("%s was removed, deleting %s from server in %s", rec.LocalPath, rec.RemotePath, grace)
+	}
+}
+
+// copyRenamed looks for a tombstoned file that this one was renamed from
+// and, if there is one, copies its object on the server instead of
+// uploading the file again.
+func (p *Processor) copyRenamed(entry QueueEntry, info os.FileInfo) *UploadResponse {
+	watch := p.cfg.WatchFor(entry.LocalPath)
+	if watch == nil || !watch.MirrorDeletes {
+		return nil
+	}
+
+	candidates, err := p.db.RenameCandidates(info.Size(), info.ModTime().UTC().Unix())
+	if err != nil {
+		log.Printf("db error for %s: %v", entry.LocalPath, err)
+		return nil
+	}
+
+	var checksum string
+	for _, t := range candidates {
+		// A file moved between watches changes remote prefix and mirror
+		// settings, so only a rename within one watch is copied.
+		if t.LocalPath == entry.LocalPath || t.SHA256 == nil || p.cfg.WatchFor(t.LocalPath) != watch {
+			continue
+		}
+
+		if checksum == "" {
+			file, err := os.Open(entry.LocalPath)
+			if err != nil {
+				return nil
+			}
+			checksum, err = hashSection(file, 0, info.Size())
+			file.Close()
+			if err != nil {
+				return nil
+			}
+		}
+		if *t.SHA256 != checksum {
+			continue
+		}
+
+		resp, err := p.uploader.Copy(t.RemotePath, entry.RemotePath, info.Size(), checksum)
+		if err != nil {
+			log.Printf("server-side copy of %s failed, uploading instead: %v", t.RemotePath, err)
+			return nil
+		}
+		if err := p.db.ExpireTombstone(t.LocalPath, time.Now().UTC().Unix()); err != nil {
+			log.Printf("db error for %s: %v", t.LocalPath, err)
 		}
-		p.queue.EnqueueWithAttempts(entry.LocalPath, entry.RemotePath, entry.AttemptCount)
+		log.Printf("%s was renamed to %s, copied %s -> %s on server", t.LocalPath, entry.LocalPath, t.RemotePath, entry.RemotePath)
+		resp.SHA256 = checksum
+		return resp
+	}
+	return nil
+}
+
+// maxRetryDelay caps the backoff between immediate retries of one file.
+const maxRetryDelay = time.Minute
+
+// uploadEntry uploads a file the stability tracker has released. Server
+// outages confirmed through /health do not count against the file: the
+// breaker pauses all workers and the upload is retried once the server is
+// back. Server errors while /health answers count as failed attempts.
+func (p *Processor) uploadEntry(entry QueueEntry, stop <-chan struct{}) {
+	info, err := os.Stat(entry.LocalPath)
+	if err != nil {
+		return
+	}
+	currentMtime := info.ModTime().UTC().Unix()
+
+	rec, err := p.db.GetFile(entry.LocalPath)
+	if err != nil {
+		log.Printf("db error for %s: %v", entry.LocalPath, err)
 		return
 	}
 
+	retryDelay := time.Duration(p.cfg.Upload.RetryDelaySeconds) * time.Second
+
+	// A forced upload is meant to replace the server copy, not copy it.
+	var resp *UploadResponse
+	if !entry.Force {
+		resp = p.copyRenamed(entry, info)
+	}
 	var lastErr error
-	for attempt := 0; attempt < p.cfg.Upload.RetryAttempts; attempt++ {
+	attempt := 0
+	for resp == nil && attempt < p.cfg.Upload.RetryAttempts {
 		if attempt > 0 {
-			time.Sleep(time.Duration(p.cfg.Upload.RetryDelaySeconds) * time.Second)
+			time.Sleep(withJitter(failedRetryDelay(attempt, retryDelay, maxRetryDelay)))
 		}
 
-		_, lastErr = p.uploader.Upload(entry.LocalPath, entry.RemotePath)
+		resp, lastErr = p.uploader.Upload(entry.LocalPath, entry.RemotePath)
 		if lastErr == nil {
 			break
 		}
-		log.Printf("upload attempt %d failed for %s: %v", attempt+1, entry.LocalPath, lastErr)
+
+		if isServerError(lastErr) && p.breaker.TripIfDown(lastErr) {
+			for p.breaker.IsOpen() {
+				if p.shouldStop(stop) {
+					// Keep the entry queued so it survives the shutdown.
+					p.queue.EnqueueEntry(QueueEntry{
+						LocalPath:    entry.LocalPath,
+						RemotePath:   entry.RemotePath,
+						AttemptCount: entry.AttemptCount,
+						Force:        entry.Force,
+					})
+					return
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			continue
+		}
+
+		attempt++
+		log.Printf("upload attempt %d failed for %s: %v", attempt, entry.LocalPath, lastErr)
+		if !isRetryable(lastErr) {
+			break
+		}
 	}
 
+	if resp == nil && lastErr == nil {
+		lastErr = errors.New("no upload attempt was made")
+	}
 	if lastErr != nil {
-		log.Printf("upload failed for %s after %d attempts: %v", entry.LocalPath, p.cfg.Upload.RetryAttempts, lastErr)
-		p.recordFailure(entry.LocalPath)
+		log.Printf("upload failed for %s after %d attempts: %v", entry.LocalPath, attempt, lastErr)
+		p.recordFailure(entry, lastErr)
 		return
 	}
 
@@ -160,11 +456,34 @@ func (p *Processor) ProcessEntry(entry QueueEntry) {
 		return
 	}
 
+	p.clearFailure(entry.LocalPath)
+	if err := p.db.DeleteTombstone(entry.LocalPath); err != nil {
+		log.Printf("db error for %s: %v", entry.LocalPath, err)
+	}
+
+	checksum := &resp.SHA256
 	if rec == nil {
-		p.db.InsertFile(entry.LocalPath, entry.RemotePath, info.Size(), currentMtime, nil)
+		p.db.InsertFile(entry.LocalPath, entry.RemotePath, info.Size(), currentMtime, checksum, nil)
 	} else {
-		p.db.UpdateFile(entry.LocalPath, entry.RemotePath, info.Size(), currentMtime, nil)
+		p.db.UpdateFile(entry.LocalPath, entry.RemotePath, info.Size(), currentMtime, checksum, nil)
 	}
 
+	p.recordStats(DailyStats{FilesUploaded: 1, BytesUploaded: info.Size()})
 	log.Printf("uploaded %s -> %s", entry.LocalPath, entry.RemotePath)
 }
+
+// recordStats adds delta to today's daily_stats row.
+func (p *Processor) recordStats(delta DailyStats) {
+	delta.Day = statsDay(time.Now())
+	if err := p.db.AddDailyStats(delta); err != nil {
+		log.Printf("failed to record daily stats: %v", err)
+	}
+}
+
+// countFile adds a skipped or failed file to today's daily_stats row, once
+// per file and day however often it is retried.
+func (p *Processor) countFile(kind, localPath string) {
+	if err := p.db.CountDailyFile(statsDay(time.Now()), kind, localPath); err != nil {
+		log.Printf("failed to record daily stats: %v", err)
+	}
+}
@@ -1,73 +1,160 @@
 package client
 
 import (
+	"log"
+	"sort"
 	"sync"
+	"time"
 )
 
 type QueueEntry struct {
 	LocalPath    string
 	RemotePath   string
 	AttemptCount int
+	NotBefore    time.Time
+	// Force uploads the file even if it is unchanged since its last upload.
+	Force bool
 }
 
 type Queue struct {
-	mu      sync.Mutex
-	entries []QueueEntry
-	set     map[string]struct{}
+	mu       sync.Mutex
+	entries  []QueueEntry
+	set      map[string]struct{}
+	inFlight map[string]struct{}
+	db       *DB
+	// highWater is the longest the queue has been since TakeHighWater.
+	highWater int
 }
 
 func NewQueue() *Queue {
 	return &Queue{
-		entries: make([]QueueEntry, 0),
-		set:     make(map[string]struct{}),
+		entries:  make([]QueueEntry, 0),
+		set:      make(map[string]struct{}),
+		inFlight: make(map[string]struct{}),
 	}
 }
 
-func (q *Queue) Enqueue(localPath, remotePath string) bool {
-	q.mu.Lock()
-	defer q.mu.Unlock()
+// NewPersistentQueue returns a queue backed by the queue table in db,
+// preloaded with the entries left there by the previous run. An entry's row
+// is kept until its path is released with Done, so work that was in flight
+// at shutdown is picked up again too.
+func NewPersistentQueue(db *DB) (*Queue, error) {
+	entries, err := db.LoadQueue()
+	if err != nil {
+		return nil, err
+	}
 
-	if _, exists := q.set[localPath]; exists {
-		return false
+	q := NewQueue()
+	q.db = db
+	for _, entry := range entries {
+		if _, exists := q.set[entry.LocalPath]; exists {
+			continue
+		}
+		q.entries = append(q.entries, entry)
+		q.set[entry.LocalPath] = struct{}{}
 	}
+	return q, nil
+}
 
-	q.entries = append(q.entries, QueueEntry{
+func (q *Queue) Enqueue(localPath, remotePath string) bool {
+	return q.EnqueueEntry(QueueEntry{
 		LocalPath:  localPath,
 		RemotePath: remotePath,
 	})
-	q.set[localPath] = struct{}{}
-	return true
 }
 
 func (q *Queue) EnqueueWithAttempts(localPath, remotePath string, attempts int) bool {
+	return q.EnqueueEntry(QueueEntry{
+		LocalPath:    localPath,
+		RemotePath:   remotePath,
+		AttemptCount: attempts,
+	})
+}
+
+// EnqueueEntry adds entry unless its path is already queued, in which case
+// a forced entry only marks the queued one as forced. Dequeue will not hand
+// it out before entry.NotBefore.
+func (q *Queue) EnqueueEntry(entry QueueEntry) bool {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
-	if _, exists := q.set[localPath]; exists {
+	if _, exists := q.set[entry.LocalPath]; exists {
+		if entry.Force {
+			q.force(entry.LocalPath)
+		}
 		return false
 	}
 
-	q.entries = append(q.entries, QueueEntry{
-		LocalPath:    localPath,
-		RemotePath:   remotePath,
-		AttemptCount: attempts,
-	})
-	q.set[localPath] = struct{}{}
+	q.entries = append(q.entries, entry)
+	q.set[entry.LocalPath] = struct{}{}
+	if len(q.entries) > q.highWater {
+		q.highWater = len(q.entries)
+	}
+
+	if q.db != nil {
+		if err := q.db.SaveQueueEntry(entry); err != nil {
+			log.Printf("failed to persist queue entry %s: %v", entry.LocalPath, err)
+		}
+	}
 	return true
 }
 
+// force marks the queued entry for localPath as forced. The caller must
+// hold q.mu.
+func (q *Queue) force(localPath string) {
+	for i := range q.entries {
+		if q.entries[i].LocalPath != localPath || q.entries[i].Force {
+			continue
+		}
+		q.entries[i].Force = true
+		if q.db != nil {
+			if err := q.db.SaveQueueEntry(q.entries[i]); err != nil {
+				log.Printf("failed to persist queue entry %s: %v", localPath, err)
+			}
+		}
+		return
+	}
+}
+
+// Dequeue returns the first eligible entry whose path is not already being
+// processed and marks that path in flight until Done is called. Entries for
+// in-flight paths stay queued so a change made during an upload is not lost.
 func (q *Queue) Dequeue() (QueueEntry, bool) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
-	if len(q.entries) == 0 {
-		return QueueEntry{}, false
+	now := time.Now()
+	for i, entry := range q.entries {
+		if _, busy := q.inFlight[entry.LocalPath]; busy {
+			continue
+		}
+		if entry.NotBefore.After(now) {
+			continue
+		}
+		if i == 0 {
+			q.entries = q.entries[1:]
+		} else {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+		}
+		delete(q.set, entry.LocalPath)
+		q.inFlight[entry.LocalPath] = struct{}{}
+		return entry, true
 	}
+	return QueueEntry{}, false
+}
+
+// Done releases a path handed out by Dequeue.
+func (q *Queue) Done(localPath string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.inFlight, localPath)
 
-	entry := q.entries[0]
-	q.entries = q.entries[1:]
-	delete(q.set, entry.LocalPath)
-	return entry, true
+	if _, requeued := q.set[localPath]; requeued || q.db == nil {
+		return
+	}
+	if err := q.db.DeleteQueueEntry(localPath); err != nil {
+		log.Printf("failed to remove queue entry %s: %v", localPath, err)
+	}
 }
 
 func (q *Queue) Len() int {
@@ -76,9 +163,38 @@ func (q *Queue) Len() int {
 	return len(q.entries)
 }
 
+// TakeHighWater returns the longest the queue has been since the last call
+// and starts a new measurement from its current length.
+func (q *Queue) TakeHighWater() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := q.highWater
+	if len(q.entries) > n {
+		n = len(q.entries)
+	}
+	q.highWater = len(q.entries)
+	return n
+}
+
 func (q *Queue) Contains(localPath string) bool {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	_, exists := q.set[localPath]
 	return exists
 }
+
+// Snapshot returns the queued entries in order and the paths currently
+// being processed.
+func (q *Queue) Snapshot() ([]QueueEntry, []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entries := make([]QueueEntry, len(q.entries))
+	copy(entries, q.entries)
+	inFlight := make([]string, 0, len(q.inFlight))
+	for path := range q.inFlight {
+		inFlight = append(inFlight, path)
+	}
+	sort.Strings(inFlight)
+	return entries, inFlight
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Reconciler periodically walks every watch and queues files the watcher
+// missed, such as changes made during an inotify overflow or while the
+// daemon was down. Only files that are new or whose mtime or size differs
+// from the files table are queued.
+type Reconciler struct {
+	queue    *Queue
+	db       *DB
+	cfg      *Config
+	interval time.Duration
+	// started stands in for the first-seen time of watches the Scanner has
+	// not recorded yet.
+	started time.Time
+}
+
+func NewReconciler(queue *Queue, db *DB, cfg *Config) *Reconciler {
+	return &Reconciler{
+		queue:    queue,
+		db:       db,
+		cfg:      cfg,
+		interval: time.Duration(cfg.Scan.IntervalMinutes) * time.Minute,
+		started:  time.Now(),
+	}
+}
+
+func (r *Reconciler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := r.Reconcile(); err != nil {
+				log.Printf("periodic rescan failed: %v", err)
+			}
+		}
+	}
+}
+
+// Reconcile walks every watch once and returns how many files it queued.
+func (r *Reconciler) Reconcile() (int, error) {
+	failed, err := r.db.ListFailedUploads()
+	if err != nil {
+		return 0, err
+	}
+	// Failed files are retried on the RetryScheduler's backoff instead.
+	skip := make(map[string]bool, len(failed))
+	for _, f := range failed {
+		skip[f.LocalPath] = true
+	}
+
+	queued := 0
+	for _, watch := range r.cfg.Watches {
+		n, err := r.reconcileWatch(watch, skip)
+		queued += n
+		if err != nil {
+			return queued, err
+		}
+	}
+
+	if queued > 0 {
+		log.Printf("periodic rescan queued %d new or changed files", queued)
+	}
+	return queued, nil
+}
+
+func (r *Reconciler) reconcileWatch(watch WatchConfig, skip map[string]bool) (int, error) {
+	recs, err := r.db.ListFilesUnder(watch.LocalPath)
+	if err != nil {
+		return 0, err
+	}
+	known := make(map[string]*FileRecord, len(recs))
+	for i := range recs {
+		known[recs[i].LocalPath] = &recs[i]
+	}
+
+	// In skip mode, unknown files older than the first time the daemon saw
+	// the watch existed before it and are left alone. Anything newer was
+	// created since, possibly while the daemon was down.
+	since := r.started
+	if r.cfg.Scan.EffectiveMode() == ScanModeSkip {
+		seenAt, err := r.db.WatchFirstSeen(watch.LocalPath)
+		if err != nil {
+			return 0, err
+		}
+		if seenAt > 0 {
+			since = time.Unix(seenAt, 0)
+		}
+	}
+
+	queued := 0
+	err = filepath.WalkDir(watch.LocalPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || skip[path] || r.queue.Contains(path) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(watch.LocalPath, path)
+		if err != nil {
+			return nil
+		}
+		remotePath := filepath.Join(watch.RemotePrefix, relPath)
+		if r.cfg.IsExcluded(remotePath) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		if rec := known[path]; rec != nil {
+			if rec.Mtime == info.ModTime().UTC().Unix() && rec.FileSize == info.Size() {
+				return nil
+			}
+		} else if r.cfg.Scan.EffectiveMode() == ScanModeSkip && info.ModTime().Before(since) {
+			return nil
+		}
+
+		if r.queue.Enqueue(path, remotePath) {
+			queued++
+		}
+		return nil
+	})
+	return queued, err
+}
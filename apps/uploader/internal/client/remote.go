@@ -0,0 +1,180 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RemoteFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// HistoryEntry is the latest recorded upload of a path, from GET /history.
+type HistoryEntry struct {
+	Path       string  `json:"path"`
+	Size       int64   `json:"size"`
+	SHA256     *string `json:"sha256"`
+	UploadedAt int64   `json:"uploaded_at"`
+}
+
+type RemoteVersion struct {
+	Path         string    `json:"path,omitempty"`
+	VersionID    string    `json:"version_id"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	IsLatest     bool      `json:"is_latest"`
+	DeleteMarker bool      `json:"delete_marker"`
+}
+
+func (u *Uploader) List(prefix string) ([]RemoteFile, error) {
+	req, err := u.newRequest("GET", "/list?"+url.Values{"prefix": {prefix}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Files []RemoteFile `json:"files"`
+	}
+	if err := u.doJSON("list", req, &result); err != nil {
+		return nil, err
+	}
+	return result.Files, nil
+}
+
+// History returns every path under prefix uploaded at or before at.
+func (u *Uploader) History(prefix string, at time.Time) ([]HistoryEntry, error) {
+	query := url.Values{"prefix": {prefix}, "at": {strconv.FormatInt(at.Unix(), 10)}}
+	req, err := u.newRequest("GET", "/history?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Files []HistoryEntry `json:"files"`
+	}
+	if err := u.doJSON("history", req, &result); err != nil {
+		return nil, err
+	}
+	return result.Files, nil
+}
+
+// Versions returns the stored versions of remotePath, newest first.
+func (u *Uploader) Versions(remotePath string) ([]RemoteVersion, error) {
+	req, err := u.newRequest("GET", "/versions?"+url.Values{"path": {remotePath}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Versions []RemoteVersion `json:"versions"`
+	}
+	if err := u.doJSON("versions", req, &result); err != nil {
+		return nil, err
+	}
+	return result.Versions, nil
+}
+
+// PrefixVersions returns the stored versions of every path under prefix,
+// keyed by path and newest first, in a single request.
+func (u *Uploader) PrefixVersions(prefix string) (map[string][]RemoteVersion, error) {
+	req, err := u.newRequest("GET", "/versions?"+url.Values{"prefix": {prefix}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Versions []RemoteVersion `json:"versions"`
+	}
+	if err := u.doJSON("versions", req, &result); err != nil {
+		return nil, err
+	}
+	byPath := make(map[string][]RemoteVersion)
+	for _, v := range result.Versions {
+		byPath[v.Path] = append(byPath[v.Path], v)
+	}
+	return byPath, nil
+}
+
+// Download writes a stored file to w. An empty versionID downloads the
+// current version.
+func (u *Uploader) Download(remotePath, versionID string, w io.Writer) (int64, error) {
+	query := url.Values{"path": {remotePath}}
+	if versionID != "" {
+		query.Set("version_id", versionID)
+	}
+	req, err := u.newRequest("GET", "/download?"+query.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, &StatusError{Op: "download", StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return n, fmt.Errorf("downloading %s: %w", remotePath, err)
+	}
+	return n, nil
+}
+
+// RemoteStat is what the server knows about a stored file. SHA256 is only
+// set when the server recorded the upload of the stored object.
+type RemoteStat struct {
+	Exists bool   `json:"exists"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func (u *Uploader) Stat(remotePath string) (*RemoteStat, error) {
+	req, err := u.newRequest("GET", "/exists?"+url.Values{"path": {remotePath}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result RemoteStat
+	if err := u.doJSON("exists", req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (u *Uploader) Exists(remotePath string) (bool, error) {
+	stat, err := u.Stat(remotePath)
+	if err != nil {
+		return false, err
+	}
+	return stat.Exists, nil
+}
+
+// DeletePrefix removes every stored file under prefix and returns how many
+// were deleted.
+func (u *Uploader) DeletePrefix(prefix string) (int, error) {
+	form := url.Values{"prefix": {prefix}}
+	req, err := u.newRequest("POST", "/delete-prefix", strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var result struct {
+		Deleted int `json:"deleted"`
+	}
+	if err := u.doJSON("delete prefix", req, &result); err != nil {
+		return 0, err
+	}
+	return result.Deleted, nil
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+func statsDay(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// Report sends finished days of daily_stats to the server.
+func (u *Uploader) Report(stats []DailyStats) error {
+	body, err := json.Marshal(map[string][]DailyStats{"reports": stats})
+	if err != nil {
+		return err
+	}
+
+	req, err := u.newRequest("POST", "/report", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var result struct{}
+	return u.doJSON("report", req, &result)
+}
+
+// Heartbeat tells the server the client is alive when it has nothing else
+// to send.
+func (u *Uploader) Heartbeat() error {
+	req, err := u.newRequest("POST", "/heartbeat", nil)
+	if err != nil {
+		return err
+	}
+	var result struct{}
+	return u.doJSON("heartbeat", req, &result)
+}
+
+// Reporter records the queue high-water mark in daily_stats and sends each
+// finished day to the server once. Days that could not be sent, because the
+// daemon or the server was down, are sent on the next attempt. It also sends
+// a heartbeat every few minutes.
+type Reporter struct {
+	queue             *Queue
+	db                *DB
+	uploader          *Uploader
+	interval          time.Duration
+	heartbeatInterval time.Duration
+	// retryDelay is how long to wait after a failed send.
+	retryDelay time.Duration
+}
+
+func NewReporter(queue *Queue, db *DB, uploader *Uploader) *Reporter {
+	return &Reporter{
+		queue:             queue,
+		db:                db,
+		uploader:          uploader,
+		interval:          time.Minute,
+		retryDelay:        10 * time.Minute,
+		heartbeatInterval: 5 * time.Minute,
+	}
+}
+
+func (r *Reporter) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	nextSend := time.Now()
+	var lastHeartbeat time.Time
+	for {
+		r.flushHighWater()
+
+		if time.Since(lastHeartbeat) >= r.heartbeatInterval {
+			if err := r.uploader.Heartbeat(); err != nil {
+				log.Printf("heartbeat failed: %v", err)
+			}
+			lastHeartbeat = time.Now()
+		}
+
+		if now := time.Now(); !now.Before(nextSend) {
+			if _, err := r.SendPending(); err != nil {
+				log.Printf("failed to send daily report: %v", err)
+				nextSend = now.Add(r.retryDelay)
+			} else {
+				nextSend = nextDailyRun(now, "00:00")
+			}
+		}
+
+		select {
+		case <-stop:
+			r.flushHighWater()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// flushHighWater records the queue's high-water mark since the last flush.
+// It also creates today's row, so a day without uploads is still reported.
+func (r *Reporter) flushHighWater() {
+	delta := DailyStats{Day: statsDay(time.Now()), QueueHighWater: r.queue.TakeHighWater()}
+	if err := r.db.AddDailyStats(delta); err != nil {
+		log.Printf("failed to record daily stats: %v", err)
+	}
+}
+
+// reportBatchSize keeps a long catch-up within the server's per-request
+// limit.
+const reportBatchSize = 100
+
+// SendPending sends every finished day that the server has not accepted yet
+// and returns how many were sent.
+func (r *Reporter) SendPending() (int, error) {
+	stats, err := r.db.UnreportedDailyStats(statsDay(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for len(stats) > 0 {
+		batch := stats
+		if len(batch) > reportBatchSize {
+			batch = batch[:reportBatchSize]
+		}
+		if err := r.uploader.Report(batch); err != nil {
+			return sent, err
+		}
+
+		now := time.Now().UTC().Unix()
+		for _, s := range batch {
+			if err := r.db.MarkDailyStatsReported(s.Day, now); err != nil {
+				return sent, err
+			}
+		}
+		sent += len(batch)
+		stats = stats[len(batch):]
+	}
+	if sent > 1 {
+		log.Printf("sent %d daily reports", sent)
+	}
+	return sent, nil
+}
@@ -0,0 +1,294 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RestoreRestored        = "restored"
+	RestoreAlreadyRestored = "already_restored"
+	RestoreSkipped         = "skipped"
+	RestoreFailed          = "failed"
+)
+
+// restoreJournal records finished files inside the destination so an
+// interrupted restore can pick up where it stopped.
+const restoreJournal = ".s3up-restore.journal"
+
+type RestoreOptions struct {
+	Watch    *WatchConfig
+	At       time.Time
+	Dest     string
+	Parallel int
+}
+
+type RestoreResult struct {
+	Path      string `json:"path"`
+	LocalPath string `json:"local_path,omitempty"`
+	VersionID string `json:"version_id,omitempty"`
+	Size      int64  `json:"size"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type RestoreReport struct {
+	Watch      string          `json:"watch"`
+	At         time.Time       `json:"at"`
+	Dest       string          `json:"dest"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	Restored   int             `json:"restored"`
+	Skipped    int             `json:"skipped"`
+	Failed     int             `json:"failed"`
+	Files      []RestoreResult `json:"files"`
+}
+
+// Restore downloads every file of a watch as it was at opts.At into
+// opts.Dest. Paths come from the server's listing and upload history; for
+// each one the newest version at or before opts.At is restored, and paths
+// that were deleted or not yet uploaded at that time are skipped.
+func Restore(u *Uploader, opts RestoreOptions) (*RestoreReport, error) {
+	report := &RestoreReport{
+		Watch:     opts.Watch.LocalPath,
+		At:        opts.At.UTC(),
+		Dest:      opts.Dest,
+		StartedAt: time.Now().UTC(),
+	}
+
+	prefix := strings.TrimSuffix(opts.Watch.RemotePrefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	paths, err := restorePaths(u, prefix, opts.At)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := u.PrefixVersions(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(opts.Dest, 0755); err != nil {
+		return nil, err
+	}
+	journalPath := filepath.Join(opts.Dest, restoreJournal)
+	done, err := loadRestoreJournal(journalPath)
+	if err != nil {
+		return nil, err
+	}
+	journal, err := os.OpenFile(journalPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer journal.Close()
+
+	parallel := opts.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	// A restore that cannot record its progress could not be resumed, so
+	// the first journal error stops handing out new files.
+	var mu sync.Mutex
+	var journalErr error
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for remotePath := range work {
+				result := restoreFile(u, remotePath, prefix, versions[remotePath], opts, done)
+
+				mu.Lock()
+				report.Files = append(report.Files, result)
+				if result.Status == RestoreRestored && journalErr == nil {
+					journalErr = appendJournal(journal, result)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, p := range paths {
+		mu.Lock()
+		failed := journalErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		work <- p
+	}
+	close(work)
+	wg.Wait()
+	if journalErr != nil {
+		return nil, fmt.Errorf("failed to write restore journal: %w", journalErr)
+	}
+
+	sort.Slice(report.Files, func(i, j int) bool { return report.Files[i].Path < report.Files[j].Path })
+	for _, r := range report.Files {
+		switch r.Status {
+		case RestoreRestored, RestoreAlreadyRestored:
+			report.Restored++
+		case RestoreSkipped:
+			report.Skipped++
+		case RestoreFailed:
+			report.Failed++
+		}
+	}
+	report.FinishedAt = time.Now().UTC()
+
+	if err := journal.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write restore journal: %w", err)
+	}
+	if report.Failed == 0 {
+		os.Remove(journalPath)
+	}
+	return report, nil
+}
+
+func appendJournal(journal *os.File, result RestoreResult) error {
+	line, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = journal.Write(append(line, '\n'))
+	return err
+}
+
+func restorePaths(u *Uploader, prefix string, at time.Time) ([]string, error) {
+	seen := make(map[string]bool)
+
+	history, err := u.History(prefix, at)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range history {
+		seen[h.Path] = true
+	}
+
+	// The listing covers files uploaded before the server kept history.
+	files, err := u.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		seen[f.Path] = true
+	}
+
+	paths := make([]string, 0, len(seen))
+	for p := range seen {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func loadRestoreJournal(path string) (map[string]string, error) {
+	done := make(map[string]string)
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r RestoreResult
+		if json.Unmarshal(scanner.Bytes(), &r) == nil {
+			done[r.Path] = r.VersionID
+		}
+	}
+	return done, scanner.Err()
+}
+
+func restoreFile(u *Uploader, remotePath, prefix string, versions []RemoteVersion, opts RestoreOptions, done map[string]string) RestoreResult {
+	result := RestoreResult{Path: remotePath}
+
+	rel := strings.TrimPrefix(remotePath, prefix)
+	localPath := filepath.Join(opts.Dest, filepath.FromSlash(rel))
+	if r, err := filepath.Rel(opts.Dest, localPath); err != nil || strings.HasPrefix(r, "..") {
+		result.Status = RestoreSkipped
+		result.Reason = "path outside destination"
+		return result
+	}
+	result.LocalPath = localPath
+
+	var version *RemoteVersion
+	for i := range versions {
+		if !versions[i].LastModified.After(opts.At) {
+			version = &versions[i]
+			break
+		}
+	}
+	switch {
+	case version == nil:
+		result.Status = RestoreSkipped
+		result.Reason = "not uploaded yet at that time"
+		return result
+	case version.DeleteMarker:
+		result.Status = RestoreSkipped
+		result.Reason = "deleted at that time"
+		return result
+	}
+	result.VersionID = version.VersionID
+	result.Size = version.Size
+
+	if done[remotePath] == version.VersionID {
+		if info, err := os.Stat(localPath); err == nil && info.Size() == version.Size {
+			result.Status = RestoreAlreadyRestored
+			return result
+		}
+	}
+
+	if err := downloadTo(u, remotePath, version, localPath); err != nil {
+		result.Status = RestoreFailed
+		result.Reason = err.Error()
+		return result
+	}
+	result.Status = RestoreRestored
+	return result
+}
+
+// downloadTo writes a version to a temp file next to localPath and renames
+// it into place, so an interrupted download never looks complete.
+func downloadTo(u *Uploader, remotePath string, version *RemoteVersion, localPath string) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), ".s3up-restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := u.Download(remotePath, version.VersionID, tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if n != version.Size {
+		return fmt.Errorf("downloaded %d bytes, expected %d", n, version.Size)
+	}
+
+	if err := os.Rename(tmp.Name(), localPath); err != nil {
+		return err
+	}
+	return os.Chtimes(localPath, version.LastModified, version.LastModified)
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// failedRetryDelay returns the backoff before retrying a file that has
+// failed attempts times: initial, doubling each time, capped at max.
+func failedRetryDelay(attempts int, initial, max time.Duration) time.Duration {
+	delay := initial
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// RetryScheduler re-queues files from the failed_uploads table once their
+// backoff has expired.
+type RetryScheduler struct {
+	queue    *Queue
+	db       *DB
+	initial  time.Duration
+	max      time.Duration
+	interval time.Duration
+}
+
+func NewRetryScheduler(queue *Queue, db *DB, cfg *Config) *RetryScheduler {
+	initial := time.Duration(cfg.Upload.FailedRetryInitialSeconds) * time.Second
+	max := time.Duration(cfg.Upload.FailedRetryMaxSeconds) * time.Second
+
+	interval := initial
+	if interval < time.Second {
+		interval = time.Second
+	}
+	if interval > 30*time.Second {
+		interval = 30 * time.Second
+	}
+
+	return &RetryScheduler{
+		queue:    queue,
+		db:       db,
+		initial:  initial,
+		max:      max,
+		interval: interval,
+	}
+}
+
+func (s *RetryScheduler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := s.RetryDue(); err != nil {
+				log.Printf("failed to schedule retries: %v", err)
+			}
+		}
+	}
+}
+
+// RetryDue queues every failed upload whose retry time has come and returns
+// how many were queued. Files that no longer exist are forgotten.
+func (s *RetryScheduler) RetryDue() (int, error) {
+	now := time.Now()
+	due, err := s.db.DueFailedUploads(now.UTC().Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	for _, f := range due {
+		if _, err := os.Stat(f.LocalPath); os.IsNotExist(err) {
+			s.db.DeleteFailedUpload(f.LocalPath)
+			continue
+		}
+
+		// Push the retry time out now, so a file that is dropped without
+		// being uploaded is not re-queued on every tick.
+		next := now.Add(failedRetryDelay(f.AttemptCount, s.initial, s.max))
+		if err := s.db.SetFailedRetryAt(f.LocalPath, next.UTC().Unix()); err != nil {
+			return queued, err
+		}
+
+		if s.queue.EnqueueEntry(QueueEntry{LocalPath: f.LocalPath, RemotePath: f.RemotePath, Force: f.Force}) {
+			queued++
+		}
+	}
+
+	if queued > 0 {
+		log.Printf("retrying %d previously failed uploads", queued)
+	}
+	return queued, nil
+}
@@ -1,24 +1,41 @@
 package client
 
 import (
+	"log"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 type Scanner struct {
 	queue *Queue
+	db    *DB
 	cfg   *Config
 }
 
-func NewScanner(queue *Queue, cfg *Config) *Scanner {
+func NewScanner(queue *Queue, db *DB, cfg *Config) *Scanner {
 	return &Scanner{
 		queue: queue,
+		db:    db,
 		cfg:   cfg,
 	}
 }
 
 func (s *Scanner) Scan() error {
-	if !s.cfg.Scan.UploadExisting {
+	switch s.cfg.Scan.EffectiveMode() {
+	case ScanModeSkip:
+		for _, watch := range s.cfg.Watches {
+			if err := s.skipWatch(watch); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ScanModeBaseline:
+		for _, watch := range s.cfg.Watches {
+			if err := s.baselineWatch(watch); err != nil {
+				return err
+			}
+		}
 		return nil
 	}
 
@@ -42,12 +59,91 @@ func (s *Scanner) scanWatch(watch WatchConfig) error {
 	})
 }
 
-func (s *Scanner) enqueueFile(localPath string, watch WatchConfig) error {
+// skipWatch records when a watch is first seen, so its existing files are
+// left alone. On later starts, files created since then were missed while
+// the daemon was down and are queued.
+func (s *Scanner) skipWatch(watch WatchConfig) error {
+	seenAt, err := s.db.WatchFirstSeen(watch.LocalPath)
+	if err != nil {
+		return err
+	}
+	if seenAt == 0 {
+		return s.db.SetWatchFirstSeen(watch.LocalPath, time.Now().UTC().Unix())
+	}
+	_, err = NewReconciler(s.queue, s.db, s.cfg).reconcileWatch(watch, nil)
+	return err
+}
+
+// baselineWatch records the files of a watch seen for the first time as
+// baseline_existing, so their later changes are uploaded while untouched
+// files are not. Once a watch has a baseline, files that are unknown or
+// changed were missed while the daemon was down and are queued.
+func (s *Scanner) baselineWatch(watch WatchConfig) error {
+	done, err := s.db.IsBaselined(watch.LocalPath)
+	if err != nil {
+		return err
+	}
+	if done {
+		_, err := NewReconciler(s.queue, s.db, s.cfg).reconcileWatch(watch, nil)
+		return err
+	}
+
+	var recs []FileRecord
+	err = filepath.WalkDir(watch.LocalPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		remotePath, err := s.remotePath(path, watch)
+		if err != nil || s.cfg.IsExcluded(remotePath) {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		recs = append(recs, FileRecord{
+			LocalPath:  path,
+			RemotePath: remotePath,
+			FileSize:   info.Size(),
+			Mtime:      info.ModTime().UTC().Unix(),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The watcher starts before the scan, so a file written during startup
+	// may already be queued. Recording it as baseline_existing would make
+	// the processor see an unchanged file and never upload it.
+	pending := recs[:0]
+	for _, rec := range recs {
+		if !s.queue.Contains(rec.LocalPath) {
+			pending = append(pending, rec)
+		}
+	}
+	recs = pending
+
+	if err := s.db.InsertBaseline(watch.LocalPath, recs); err != nil {
+		return err
+	}
+	log.Printf("recorded %d existing files in %s as baseline", len(recs), watch.LocalPath)
+	return nil
+}
+
+func (s *Scanner) remotePath(localPath string, watch WatchConfig) (string, error) {
 	relPath, err := filepath.Rel(watch.LocalPath, localPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(watch.RemotePrefix, relPath), nil
+}
+
+func (s *Scanner) enqueueFile(localPath string, watch WatchConfig) error {
+	remotePath, err := s.remotePath(localPath, watch)
 	if err != nil {
 		return err
 	}
-	remotePath := filepath.Join(watch.RemotePrefix, relPath)
 	if s.cfg.IsExcluded(remotePath) {
 		return nil
 	}
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"container/heap"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+type stabilityItem struct {
+	entry    QueueEntry
+	size     int64
+	modTime  time.Time
+	deadline time.Time
+	index    int
+}
+
+type stabilityHeap []*stabilityItem
+
+func (h stabilityHeap) Len() int           { return len(h) }
+func (h stabilityHeap) Less(i, j int) bool { return h[i].deadline.Before(h[j].deadline) }
+func (h stabilityHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *stabilityHeap) Push(x interface{}) {
+	item := x.(*stabilityItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *stabilityHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// StabilityTracker holds files that are waiting for their mtime and size to
+// settle. Each file is re-checked when its debounce deadline passes instead
+// of a worker sleeping on it, so any number of files can wait at once.
+//
+// Tracked paths stay in flight on the queue; files that vanish or never
+// settle are released back to it.
+type StabilityTracker struct {
+	queue       *Queue
+	debounce    time.Duration
+	maxAttempts int
+
+	mu      sync.Mutex
+	pending stabilityHeap
+	ready   []QueueEntry
+	wake    chan struct{}
+}
+
+func NewStabilityTracker(queue *Queue, debounce time.Duration, maxAttempts int) *StabilityTracker {
+	return &StabilityTracker{
+		queue:       queue,
+		debounce:    debounce,
+		maxAttempts: maxAttempts,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Add starts tracking entry from the given stat result.
+func (t *StabilityTracker) Add(entry QueueEntry, info os.FileInfo) {
+	t.mu.Lock()
+	heap.Push(&t.pending, &stabilityItem{
+		entry:    entry,
+		size:     info.Size(),
+		modTime:  info.ModTime(),
+		deadline: time.Now().Add(t.debounce),
+	})
+	t.mu.Unlock()
+
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// PopReady returns a file that has held steady for the debounce window.
+func (t *StabilityTracker) PopReady() (QueueEntry, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.ready) == 0 {
+		return QueueEntry{}, false
+	}
+	entry := t.ready[0]
+	t.ready = t.ready[1:]
+	return entry, true
+}
+
+func (t *StabilityTracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending) + len(t.ready)
+}
+
+// Run re-checks files as their deadlines expire until stop is closed.
+func (t *StabilityTracker) Run(stop <-chan struct{}) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		t.checkDue(time.Now())
+
+		wait := time.Hour
+		t.mu.Lock()
+		if len(t.pending) > 0 {
+			wait = time.Until(t.pending[0].deadline)
+		}
+		t.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-stop:
+			return
+		case <-t.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+func (t *StabilityTracker) checkDue(now time.Time) {
+	t.mu.Lock()
+	var due []*stabilityItem
+	for len(t.pending) > 0 && !t.pending[0].deadline.After(now) {
+		due = append(due, heap.Pop(&t.pending).(*stabilityItem))
+	}
+	t.mu.Unlock()
+
+	for _, item := range due {
+		t.check(item)
+	}
+}
+
+func (t *StabilityTracker) check(item *stabilityItem) {
+	info, err := os.Stat(item.entry.LocalPath)
+	if err != nil {
+		t.queue.Done(item.entry.LocalPath)
+		return
+	}
+
+	if info.ModTime().Equal(item.modTime) && info.Size() == item.size {
+		t.mu.Lock()
+		t.ready = append(t.ready, item.entry)
+		t.mu.Unlock()
+		return
+	}
+
+	item.entry.AttemptCount++
+	if item.entry.AttemptCount >= t.maxAttempts {
+		log.Printf("giving up on %s after %d stability attempts", item.entry.LocalPath, item.entry.AttemptCount)
+		t.queue.Done(item.entry.LocalPath)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	item.size = info.Size()
+	item.modTime = info.ModTime()
+	item.deadline = time.Now().Add(t.debounce)
+	heap.Push(&t.pending, item)
+}
@@ -0,0 +1,85 @@
+package client
+
+type Status struct {
+	// DaemonRunning and Paused are only known when the status comes from
+	// the daemon's control socket.
+	DaemonRunning bool           `json:"daemon_running"`
+	Paused        bool           `json:"paused"`
+	Uploaded      int            `json:"uploaded"`
+	UploadedBytes int64          `json:"uploaded_bytes"`
+	Skipped       map[string]int `json:"skipped"`
+	Queued        int            `json:"queued"`
+	Watches       []WatchStatus  `json:"watches"`
+	Failures      []FailureInfo  `json:"recent_failures"`
+	FailureCount  int            `json:"failure_count"`
+}
+
+type WatchStatus struct {
+	LocalPath      string `json:"local_path"`
+	RemotePrefix   string `json:"remote_prefix"`
+	Uploaded       int    `json:"uploaded"`
+	UploadedBytes  int64  `json:"uploaded_bytes"`
+	LastUploadedAt *int64 `json:"last_uploaded_at"`
+}
+
+type FailureInfo struct {
+	LocalPath    string `json:"local_path"`
+	Error        string `json:"error"`
+	AttemptCount int    `json:"attempt_count"`
+	LastFailedAt int64  `json:"last_failed_at"`
+	NextRetryAt  int64  `json:"next_retry_at"`
+}
+
+// CollectStatus summarizes what the client has done from its database. It
+// only reads, so it works whether or not the daemon is running; Queued is
+// the persisted queue, which the daemon keeps in step with its own.
+// At most maxFailures of the most recent failures are included.
+func CollectStatus(db *DB, cfg *Config, maxFailures int) (*Status, error) {
+	var status Status
+	var err error
+
+	if status.Uploaded, status.UploadedBytes, _, err = db.UploadStats(""); err != nil {
+		return nil, err
+	}
+	if status.Skipped, err = db.SkipCounts(); err != nil {
+		return nil, err
+	}
+	if status.Queued, err = db.QueueLen(); err != nil {
+		return nil, err
+	}
+
+	status.Watches = []WatchStatus{}
+	for _, w := range cfg.Watches {
+		ws := WatchStatus{LocalPath: w.LocalPath, RemotePrefix: w.RemotePrefix}
+		if ws.Uploaded, ws.UploadedBytes, ws.LastUploadedAt, err = db.UploadStats(w.LocalPath); err != nil {
+			return nil, err
+		}
+		status.Watches = append(status.Watches, ws)
+	}
+
+	failed, err := db.ListFailedUploads()
+	if err != nil {
+		return nil, err
+	}
+	status.FailureCount = len(failed)
+	if maxFailures >= 0 && len(failed) > maxFailures {
+		failed = failed[:maxFailures]
+	}
+	status.Failures = failureInfos(failed)
+
+	return &status, nil
+}
+
+func failureInfos(failed []FailedUpload) []FailureInfo {
+	infos := make([]FailureInfo, 0, len(failed))
+	for _, f := range failed {
+		infos = append(infos, FailureInfo{
+			LocalPath:    f.LocalPath,
+			Error:        f.Error,
+			AttemptCount: f.AttemptCount,
+			LastFailedAt: f.LastFailedAt,
+			NextRetryAt:  f.NextRetryAt,
+		})
+	}
+	return infos
+}
@@ -1,84 +1,236 @@
 package client
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 )
 
 type Uploader struct {
-	cfg    *Config
-	client *http.Client
+	cfg       *Config
+	db        *DB
+	client    *http.Client
+	chunkSize int64
 }
 
 type UploadResponse struct {
 	Success bool   `json:"success"`
 	S3Key   string `json:"s3_key"`
 	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
 }
 
-func NewUploader(cfg *Config) *Uploader {
-	return &Uploader{
-		cfg:    cfg,
-		client: &http.Client{},
+// StatusError is returned when the server answers with a non-200 status.
+type StatusError struct {
+	Op         string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s failed with status %d: %s", e.Op, e.StatusCode, e.Body)
+}
+
+// Unwrap lets callers match the server's checksum rejection with errors.Is.
+func (e *StatusError) Unwrap() error {
+	if e.StatusCode == http.StatusUnprocessableEntity {
+		return ErrChecksumMismatch
 	}
+	return nil
 }
 
-func (u *Uploader) Upload(localPath, remotePath string) (*UploadResponse, error) {
-	file, err := os.Open(localPath)
+// Version is the client build, set with -ldflags at build time. It is sent
+// to the server with every request, together with the hostname.
+var Version = "dev"
+
+var hostname, _ = os.Hostname()
+
+func NewUploader(cfg *Config, db *DB) *Uploader {
+	httpClient := &http.Client{}
+	// LoadConfig has already checked the TLS files, so an error here means
+	// they changed since; requests will then fail with a TLS error.
+	tlsConfig, err := cfg.Server.TLSConfig()
 	if err != nil {
-		return nil, err
+		log.Printf("failed to load tls config: %v", err)
+	}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		httpClient.Transport = transport
 	}
-	defer file.Close()
 
-	var body bytes.Buffer
-	writer := multipart.NewWriter(&body)
+	return &Uploader{
+		cfg:       cfg,
+		db:        db,
+		client:    httpClient,
+		chunkSize: int64(cfg.Upload.ChunkSizeMB) * 1024 * 1024,
+	}
+}
 
-	part, err := writer.CreateFormFile("file", filepath.Base(localPath))
+func (u *Uploader) newRequest(method, endpoint string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, u.cfg.Server.URL+endpoint, body)
 	if err != nil {
 		return nil, err
 	}
+	if u.cfg.Server.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+u.cfg.Server.APIKey)
+	}
+	req.Header.Set("X-Client-Hostname", hostname)
+	req.Header.Set("X-Client-Version", Version)
+	return req, nil
+}
 
-	if _, err := io.Copy(part, file); err != nil {
+// doJSON sends req and decodes a successful JSON response into out.
+func (u *Uploader) doJSON(op string, req *http.Request, out interface{}) error {
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return &StatusError{Op: op, StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// writeMultipartHeader writes the form fields, which the server requires
+// before the file part so it can stream the file straight into storage.
+func writeMultipartHeader(w *multipart.Writer, fileName, remotePath string, size int64, checksum string) (io.Writer, error) {
+	if err := w.WriteField("path", remotePath); err != nil {
+		return nil, err
+	}
+	if err := w.WriteField("size", strconv.FormatInt(size, 10)); err != nil {
 		return nil, err
 	}
+	if err := w.WriteField("sha256", checksum); err != nil {
+		return nil, err
+	}
+	return w.CreateFormFile("file", fileName)
+}
 
-	if err := writer.WriteField("path", remotePath); err != nil {
+// multipartOverhead returns the number of bytes the multipart framing adds
+// around the file content, so the request can be sent with a known length.
+func multipartOverhead(boundary, fileName, remotePath string, size int64, checksum string) (int64, error) {
+	var cw countingWriter
+	w := multipart.NewWriter(&cw)
+	if err := w.SetBoundary(boundary); err != nil {
+		return 0, err
+	}
+	if _, err := writeMultipartHeader(w, fileName, remotePath, size, checksum); err != nil {
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	return cw.n, nil
+}
+
+func (u *Uploader) Upload(localPath, remotePath string) (*UploadResponse, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
 		return nil, err
 	}
 
-	if err := writer.Close(); err != nil {
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", u.cfg.Server.URL+"/upload", &body)
+	if u.db != nil && u.chunkSize > 0 && info.Size() > u.chunkSize {
+		defer file.Close()
+		return u.uploadMultipart(file, info, localPath, remotePath)
+	}
+
+	// S3 needs the digest before the body, so hash in a separate pass; the
+	// upload stream is hashed again to catch the file changing in between.
+	// This reads the file twice; see "POST /upload" in DESIGN.md.
+	checksum, err := hashSection(file, 0, info.Size())
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
 
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Authorization", "Bearer "+u.cfg.Server.APIKey)
+	return u.uploadSingle(file, info.Size(), checksum, localPath, remotePath)
+}
 
-	resp, err := u.client.Do(req)
+func writeUploadBody(writer *multipart.Writer, file *os.File, size int64, checksum, fileName, remotePath string) error {
+	part, err := writeMultipartHeader(writer, fileName, remotePath, size, checksum)
 	if err != nil {
+		return err
+	}
+	body := newVerifyingReader(io.NewSectionReader(file, 0, size), checksum)
+	if _, err := io.Copy(part, body); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// uploadSingle sends the whole file in one request. It takes ownership of
+// file and closes it once the body has been written.
+func (u *Uploader) uploadSingle(file *os.File, size int64, checksum, localPath, remotePath string) (*UploadResponse, error) {
+	fileName := filepath.Base(localPath)
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	overhead, err := multipartOverhead(writer.Boundary(), fileName, remotePath, size, checksum)
+	if err != nil {
+		file.Close()
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(respBody))
+	req, err := u.newRequest("POST", "/upload", pr)
+	if err != nil {
+		file.Close()
+		return nil, err
 	}
 
+	req.ContentLength = overhead + size
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	writeErr := make(chan error, 1)
+	go func() {
+		defer file.Close()
+		err := writeUploadBody(writer, file, size, checksum, fileName, remotePath)
+		if err != nil {
+			err = fmt.Errorf("reading %s: %w", localPath, err)
+		}
+		pw.CloseWithError(err)
+		writeErr <- err
+	}()
+
 	var result UploadResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := u.doJSON("upload", req, &result); err != nil {
+		pr.CloseWithError(err)
+		if werr := <-writeErr; errors.Is(werr, ErrChecksumMismatch) {
+			return nil, fmt.Errorf("%s changed during upload: %w", localPath, werr)
+		}
 		return nil, err
 	}
+	if result.SHA256 != "" && result.SHA256 != checksum {
+		return nil, fmt.Errorf("server stored sha256 %s, expected %s: %w", result.SHA256, checksum, ErrChecksumMismatch)
+	}
+	result.SHA256 = checksum
 
 	return &result, nil
 }
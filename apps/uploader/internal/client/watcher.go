@@ -4,7 +4,6 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/fsnotify/fsnotify"
 )
@@ -69,6 +68,11 @@ func (w *Watcher) processEvents() {
 }
 
 func (w *Watcher) handleEvent(event fsnotify.Event) {
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		w.handleRemoval(event.Name)
+		return
+	}
+
 	if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
 		return
 	}
@@ -80,12 +84,42 @@ func (w *Watcher) handleEvent(event fsnotify.Event) {
 
 	if info.IsDir() {
 		if event.Op&fsnotify.Create != 0 {
-			w.watcher.Add(event.Name)
+			w.addDirectory(event.Name)
 		}
 		return
 	}
 
-	remotePath := w.getRemotePath(event.Name)
+	w.enqueue(event.Name)
+}
+
+// handleRemoval queues a removed or renamed-away path on mirror_deletes
+// watches; the processor finds it missing and tombstones it.
+func (w *Watcher) handleRemoval(localPath string) {
+	watch := w.cfg.WatchFor(localPath)
+	if watch == nil || !watch.MirrorDeletes {
+		return
+	}
+	w.enqueue(localPath)
+}
+
+// addDirectory watches a new directory and queues the files already in it,
+// which is how a directory moved into a watch shows up.
+func (w *Watcher) addDirectory(root string) {
+	filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			w.watcher.Add(path)
+			return nil
+		}
+		w.enqueue(path)
+		return nil
+	})
+}
+
+func (w *Watcher) enqueue(localPath string) {
+	remotePath := w.getRemotePath(localPath)
 	if remotePath == "" {
 		return
 	}
@@ -94,20 +128,19 @@ func (w *Watcher) handleEvent(event fsnotify.Event) {
 		return
 	}
 
-	w.queue.Enqueue(event.Name, remotePath)
+	w.queue.Enqueue(localPath, remotePath)
 }
 
 func (w *Watcher) getRemotePath(localPath string) string {
-	for _, watch := range w.cfg.Watches {
-		if strings.HasPrefix(localPath, watch.LocalPath) {
-			relPath, err := filepath.Rel(watch.LocalPath, localPath)
-			if err != nil {
-				continue
-			}
-			return filepath.Join(watch.RemotePrefix, relPath)
-		}
+	watch := w.cfg.WatchFor(localPath)
+	if watch == nil {
+		return ""
+	}
+	relPath, err := filepath.Rel(watch.LocalPath, localPath)
+	if err != nil {
+		return ""
 	}
-	return ""
+	return filepath.Join(watch.RemotePrefix, relPath)
 }
 
 func (w *Watcher) Close() error {
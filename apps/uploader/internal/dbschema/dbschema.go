@@ -0,0 +1,41 @@
+// Package dbschema holds schema helpers shared by the client and server
+// SQLite databases.
+package dbschema
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// AddColumnIfMissing upgrades tables created by older versions.
+func AddColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// PrefixEnd returns an upper bound for the TEXT values starting with prefix,
+// so `col >= prefix AND col < PrefixEnd(prefix)` selects exactly them. UTF-8
+// never contains the byte 0xff. Comparing with substr would not work: Go's
+// len counts bytes where SQLite's substr counts characters.
+func PrefixEnd(prefix string) string {
+	return prefix + "\xff"
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	AlertClientStale     = "client.stale"
+	AlertClientRecovered = "client.recovered"
+)
+
+func isAlertEvent(event string) bool {
+	return event == AlertClientStale || event == AlertClientRecovered
+}
+
+const (
+	alertStateOK    = "ok"
+	alertStateStale = "stale"
+)
+
+type ClientAlert struct {
+	Event             string `json:"event"`
+	ClientID          string `json:"client_id"`
+	Hostname          string `json:"hostname"`
+	Version           string `json:"version"`
+	LastSeen          int64  `json:"last_seen"`
+	SilenceSeconds    int64  `json:"silence_seconds"`
+	MaxSilenceSeconds int64  `json:"max_silence_seconds"`
+	At                int64  `json:"at"`
+}
+
+// Alerter checks every configured client against the max-silence threshold
+// and queues a webhook to each URL when a client goes stale or recovers.
+// The last alerted state is kept in the alert_state table, so a restart
+// does not alert again.
+type Alerter struct {
+	db         *DB
+	health     *HealthRegistry
+	auth       *AuthMiddleware
+	webhooks   []string
+	maxSilence time.Duration
+	interval   time.Duration
+}
+
+func NewAlerter(db *DB, health *HealthRegistry, auth *AuthMiddleware, cfg AlertsConfig) *Alerter {
+	a := &Alerter{
+		db:         db,
+		health:     health,
+		auth:       auth,
+		webhooks:   cfg.Webhooks,
+		maxSilence: time.Duration(cfg.MaxSilenceMinutes) * time.Minute,
+		interval:   time.Duration(cfg.CheckIntervalSeconds) * time.Second,
+	}
+	if a.maxSilence <= 0 {
+		a.maxSilence = time.Hour
+	}
+	if a.interval <= 0 {
+		a.interval = time.Minute
+	}
+	return a
+}
+
+func (a *Alerter) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := a.Check(); err != nil {
+			log.Printf("client alert check failed: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check compares every client with its last alerted state and returns how
+// many changed. Clients that were never seen are not alerted on.
+func (a *Alerter) Check() (int, error) {
+	now := time.Now().UTC()
+	changed := 0
+	for _, c := range a.health.Statuses(a.auth.ClientIDs()) {
+		if c.LastSeen == 0 {
+			continue
+		}
+
+		silence := now.Sub(time.Unix(c.LastSeen, 0))
+		state := alertStateOK
+		if silence > a.maxSilence {
+			state = alertStateStale
+		}
+
+		prev, err := a.db.GetAlertState(c.ID)
+		if err != nil {
+			return changed, err
+		}
+		if prev == state || (prev == "" && state == alertStateOK) {
+			continue
+		}
+
+		event := AlertClientStale
+		if state == alertStateOK {
+			event = AlertClientRecovered
+		}
+		payload, err := json.Marshal(ClientAlert{
+			Event:             event,
+			ClientID:          c.ID,
+			Hostname:          c.Hostname,
+			Version:           c.Version,
+			LastSeen:          c.LastSeen,
+			SilenceSeconds:    int64(silence / time.Second),
+			MaxSilenceSeconds: int64(a.maxSilence / time.Second),
+			At:                now.Unix(),
+		})
+		if err != nil {
+			return changed, err
+		}
+
+		alerts := make([]OutboxEntry, 0, len(a.webhooks))
+		for _, url := range a.webhooks {
+			alerts = append(alerts, OutboxEntry{
+				URL:      url,
+				Event:    event,
+				Payload:  payload,
+				DedupKey: fmt.Sprintf("%s:%s:%d:%s", event, c.ID, c.LastSeen, url),
+			})
+		}
+		if err := a.db.SetAlertState(c.ID, state, now.Unix(), alerts); err != nil {
+			return changed, err
+		}
+		log.Printf("client %s is %s (last seen %s ago)", c.ID, state, silence.Round(time.Second))
+		changed++
+	}
+	return changed, nil
+}
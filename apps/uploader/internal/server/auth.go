@@ -2,9 +2,13 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"log"
 	"net/http"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
@@ -15,26 +19,72 @@ type contextKey string
 
 const clientIDKey contextKey = "clientID"
 
+// AuthMiddleware identifies the client behind a request by its bearer API
+// key or by its verified TLS client certificate.
 type AuthMiddleware struct {
-	mu      sync.RWMutex
-	clients map[string]string // apiKey -> clientID
+	mu           sync.RWMutex
+	clients      map[string]string // apiKey -> clientID
+	subjects     map[string]string // certificate subject -> clientID
+	fingerprints map[string]string // SPKI SHA-256 -> clientID
+	ids          []string
 }
 
 func NewAuthMiddleware(clients []ClientEntry) *AuthMiddleware {
-	m := &AuthMiddleware{
-		clients: make(map[string]string),
+	m := &AuthMiddleware{}
+	m.UpdateClients(clients)
+	return m
+}
+
+// CertFingerprint returns the hex SHA-256 of a certificate's
+// SubjectPublicKeyInfo, the form used by cert_fingerprint.
+func CertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeFingerprint accepts a hex SHA-256 in either case, optionally
+// colon-separated or prefixed with "sha256:".
+func normalizeFingerprint(s string) (string, bool) {
+	s = strings.TrimPrefix(strings.ToLower(s), "sha256:")
+	s = strings.ReplaceAll(s, ":", "")
+	if b, err := hex.DecodeString(s); err != nil || len(b) != sha256.Size {
+		return "", false
 	}
-	for _, c := range clients {
-		m.clients[c.APIKey] = c.ID
+	return s, true
+}
+
+// certClientID maps the verified client certificate of r to a client ID,
+// checking the fingerprint before the subject. hasCert reports whether a
+// verified certificate was presented at all.
+func (a *AuthMiddleware) certClientID(r *http.Request) (id string, ok, hasCert bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", false, false
 	}
-	return m
+	leaf := r.TLS.VerifiedChains[0][0]
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if id, ok = a.fingerprints[CertFingerprint(leaf)]; ok {
+		return id, true, true
+	}
+	id, ok = a.subjects[leaf.Subject.String()]
+	return id, ok, true
 }
 
 func (a *AuthMiddleware) Wrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		certID, certOK, hasCert := a.certClientID(r)
+
 		auth := r.Header.Get("Authorization")
 		if auth == "" {
-			http.Error(w, "missing authorization header", http.StatusUnauthorized)
+			switch {
+			case certOK:
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIDKey, certID)))
+			case hasCert:
+				http.Error(w, "unknown client certificate", http.StatusUnauthorized)
+			default:
+				http.Error(w, "missing authorization header", http.StatusUnauthorized)
+			}
 			return
 		}
 
@@ -53,19 +103,54 @@ func (a *AuthMiddleware) Wrap(next http.Handler) http.Handler {
 			http.Error(w, "invalid api key", http.StatusUnauthorized)
 			return
 		}
+		// A client migrating to certificates may send both; they have to
+		// agree.
+		if certOK && certID != clientID {
+			http.Error(w, "client certificate and api key belong to different clients", http.StatusUnauthorized)
+			return
+		}
 
 		ctx := context.WithValue(r.Context(), clientIDKey, clientID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// ClientIDs returns the IDs of the configured clients, sorted.
+func (a *AuthMiddleware) ClientIDs() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return append([]string(nil), a.ids...)
+}
+
 func (a *AuthMiddleware) UpdateClients(clients []ClientEntry) {
-	m := make(map[string]string, len(clients))
+	keys := make(map[string]string, len(clients))
+	subjects := make(map[string]string)
+	fingerprints := make(map[string]string)
+	seen := make(map[string]bool, len(clients))
+	ids := make([]string, 0, len(clients))
 	for _, c := range clients {
-		m[c.APIKey] = c.ID
+		if c.APIKey != "" {
+			keys[c.APIKey] = c.ID
+		}
+		if c.CertSubject != "" {
+			subjects[c.CertSubject] = c.ID
+		}
+		if fp, ok := normalizeFingerprint(c.CertFingerprint); ok {
+			fingerprints[fp] = c.ID
+		}
+		if !seen[c.ID] {
+			seen[c.ID] = true
+			ids = append(ids, c.ID)
+		}
 	}
+	sort.Strings(ids)
+
 	a.mu.Lock()
-	a.clients = m
+	a.clients = keys
+	a.subjects = subjects
+	a.fingerprints = fingerprints
+	a.ids = ids
 	a.mu.Unlock()
 }
 
@@ -112,6 +197,35 @@ func (a *AuthMiddleware) WatchClientsFile(path string) (*fsnotify.Watcher, error
 	return watcher, nil
 }
 
+// AdminAuth guards admin endpoints with their own API keys, separate from
+// the client keys.
+type AdminAuth struct {
+	keys map[string]struct{}
+}
+
+func NewAdminAuth(apiKeys []string) *AdminAuth {
+	a := &AdminAuth{keys: make(map[string]struct{}, len(apiKeys))}
+	for _, k := range apiKeys {
+		a.keys[k] = struct{}{}
+	}
+	return a
+}
+
+func (a *AdminAuth) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			http.Error(w, "missing admin authorization", http.StatusUnauthorized)
+			return
+		}
+		if _, ok := a.keys[strings.TrimPrefix(auth, "Bearer ")]; !ok {
+			http.Error(w, "invalid admin api key", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func GetClientID(ctx context.Context) string {
 	if id, ok := ctx.Value(clientIDKey).(string); ok {
 		return id
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+
+	"github.com/aws/smithy-go"
+)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrSizeMismatch is returned when a copy source is not the size the client
+// expected.
+var ErrSizeMismatch = errors.New("size mismatch")
+
+func isValidSHA256(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 32
+}
+
+// sha256Base64 converts the hex digest clients send into the base64 form
+// S3 expects in ChecksumSHA256.
+func sha256Base64(hexDigest string) *string {
+	if hexDigest == "" {
+		return nil
+	}
+	b, _ := hex.DecodeString(hexDigest)
+	s := base64.StdEncoding.EncodeToString(b)
+	return &s
+}
+
+func mapChecksumError(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "BadDigest" {
+		return ErrChecksumMismatch
+	}
+	return err
+}
@@ -8,15 +8,35 @@ import (
 )
 
 type Config struct {
-	Server        ServerConfig   `yaml:"server"`
-	S3            S3Config       `yaml:"s3"`
-	Database      DatabaseConfig `yaml:"database"`
-	ClientsConfig string         `yaml:"clients_config"`
+	Server        ServerConfig     `yaml:"server"`
+	S3            S3Config         `yaml:"s3"`
+	Database      DatabaseConfig   `yaml:"database"`
+	Versioning    VersioningConfig `yaml:"versioning"`
+	Admin         AdminConfig      `yaml:"admin"`
+	ClientHealth  HealthConfig     `yaml:"client_health"`
+	Alerts        AlertsConfig     `yaml:"alerts"`
+	Webhooks      []WebhookConfig  `yaml:"webhooks"`
+	ClientsConfig string           `yaml:"clients_config"`
 }
 
 type ServerConfig struct {
-	Host string `yaml:"host"`
-	Port int    `yaml:"port"`
+	Host string    `yaml:"host"`
+	Port int       `yaml:"port"`
+	TLS  TLSConfig `yaml:"tls"`
+}
+
+// TLSConfig serves HTTPS with the certificate in CertFile and KeyFile,
+// reloaded whenever either file changes. MinVersion is "1.2" (the default)
+// or "1.3". CipherSuites names the TLS 1.2 suites to allow, as named by
+// crypto/tls; empty keeps Go's defaults. With ClientCAFile set, clients
+// may authenticate with a certificate issued by one of its CAs.
+type TLSConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	CertFile     string   `yaml:"cert_file"`
+	KeyFile      string   `yaml:"key_file"`
+	MinVersion   string   `yaml:"min_version"`
+	CipherSuites []string `yaml:"cipher_suites"`
+	ClientCAFile string   `yaml:"client_ca_file"`
 }
 
 type S3Config struct {
@@ -28,9 +48,52 @@ type S3Config struct {
 	SecretAccessKey string `yaml:"secret_access_key"`
 }
 
+// ClientEntry identifies a client by API key, by its TLS client
+// certificate, or by both. CertSubject matches the certificate subject
+// as written by crypto/x509 (e.g. "CN=web1,O=MyCompany"); CertFingerprint
+// is the hex SHA-256 of its SubjectPublicKeyInfo.
 type ClientEntry struct {
-	ID     string `yaml:"id"`
-	APIKey string `yaml:"api_key"`
+	ID              string `yaml:"id"`
+	APIKey          string `yaml:"api_key"`
+	CertSubject     string `yaml:"cert_subject"`
+	CertFingerprint string `yaml:"cert_fingerprint"`
+}
+
+// VersioningConfig controls retention of prior object versions. The S3
+// bucket must have versioning enabled for versions to be kept at all.
+type VersioningConfig struct {
+	MaxVersions int `yaml:"max_versions"`
+}
+
+// AdminConfig lists the API keys accepted by admin endpoints such as
+// GET /clients. Without keys the admin endpoints are not served.
+type AdminConfig struct {
+	APIKeys []string `yaml:"api_keys"`
+}
+
+// HealthConfig sets when a client counts as stale: no request for
+// StaleAfterMinutes, or no daily report for ReportStaleAfterHours.
+type HealthConfig struct {
+	StaleAfterMinutes     int `yaml:"stale_after_minutes"`
+	ReportStaleAfterHours int `yaml:"report_stale_after_hours"`
+}
+
+// AlertsConfig sends a webhook when a client has been silent for longer
+// than MaxSilenceMinutes, and again when it comes back. Alerts are signed
+// with Secret when it is set, independently of the webhooks section.
+type AlertsConfig struct {
+	Webhooks             []string `yaml:"webhooks"`
+	Secret               string   `yaml:"secret"`
+	MaxSilenceMinutes    int      `yaml:"max_silence_minutes"`
+	CheckIntervalSeconds int      `yaml:"check_interval_seconds"`
+}
+
+// WebhookConfig is an endpoint for file events. Deliveries are signed with
+// Secret when it is set. An empty Events list subscribes to every event.
+type WebhookConfig struct {
+	URL    string   `yaml:"url"`
+	Secret string   `yaml:"secret"`
+	Events []string `yaml:"events"`
 }
 
 type DatabaseConfig struct {
@@ -48,6 +111,45 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
+	if cfg.Server.TLS.Enabled {
+		if cfg.Server.TLS.CertFile == "" || cfg.Server.TLS.KeyFile == "" {
+			return nil, fmt.Errorf("server.tls needs cert_file and key_file")
+		}
+		if _, err := parseTLSVersion(cfg.Server.TLS.MinVersion); err != nil {
+			return nil, err
+		}
+		if _, err := parseCipherSuites(cfg.Server.TLS.CipherSuites); err != nil {
+			return nil, err
+		}
+	} else if cfg.Server.TLS.ClientCAFile != "" {
+		return nil, fmt.Errorf("server.tls.client_ca_file needs tls enabled")
+	}
+	if cfg.ClientHealth.StaleAfterMinutes < 0 || cfg.ClientHealth.ReportStaleAfterHours < 0 {
+		return nil, fmt.Errorf("client_health thresholds must not be negative")
+	}
+	if cfg.Alerts.MaxSilenceMinutes == 0 {
+		cfg.Alerts.MaxSilenceMinutes = 60
+	}
+	if cfg.Alerts.CheckIntervalSeconds == 0 {
+		cfg.Alerts.CheckIntervalSeconds = 60
+	}
+	if len(cfg.Alerts.Webhooks) > 0 && cfg.Database.Path == "" {
+		return nil, fmt.Errorf("alerts need database.path to store alert state")
+	}
+	for _, wh := range cfg.Webhooks {
+		if wh.URL == "" {
+			return nil, fmt.Errorf("webhook without url")
+		}
+		for _, event := range wh.Events {
+			if !isFileEvent(event) {
+				return nil, fmt.Errorf("webhook %s: unknown event %q", wh.URL, event)
+			}
+		}
+	}
+	if len(cfg.Webhooks) > 0 && cfg.Database.Path == "" {
+		return nil, fmt.Errorf("webhooks need database.path for the delivery outbox")
+	}
+
 	return &cfg, nil
 }
 
@@ -66,12 +168,36 @@ func LoadClientsConfig(path string) ([]ClientEntry, error) {
 		return nil, err
 	}
 
-	seen := make(map[string]string, len(cf.Clients))
-	for _, c := range cf.Clients {
-		if prev, ok := seen[c.APIKey]; ok {
-			return nil, fmt.Errorf("duplicate api_key between clients %q and %q", prev, c.ID)
+	seen := map[string]map[string]string{
+		"api_key":          {},
+		"cert_subject":     {},
+		"cert_fingerprint": {},
+	}
+	for i, c := range cf.Clients {
+		if c.CertFingerprint != "" {
+			fp, ok := normalizeFingerprint(c.CertFingerprint)
+			if !ok {
+				return nil, fmt.Errorf("client %q: cert_fingerprint must be a hex SHA-256", c.ID)
+			}
+			cf.Clients[i].CertFingerprint = fp
+		}
+		c = cf.Clients[i]
+		if c.APIKey == "" && c.CertSubject == "" && c.CertFingerprint == "" {
+			return nil, fmt.Errorf("client %q needs an api_key, cert_subject or cert_fingerprint", c.ID)
+		}
+		for field, value := range map[string]string{
+			"api_key":          c.APIKey,
+			"cert_subject":     c.CertSubject,
+			"cert_fingerprint": c.CertFingerprint,
+		} {
+			if value == "" {
+				continue
+			}
+			if prev, ok := seen[field][value]; ok {
+				return nil, fmt.Errorf("duplicate %s between clients %q and %q", field, prev, c.ID)
+			}
+			seen[field][value] = c.ID
 		}
-		seen[c.APIKey] = c.ID
 	}
 
 	return cf.Clients, nil
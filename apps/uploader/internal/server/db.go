@@ -4,9 +4,12 @@ import (
 	"database/sql"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
+
+	"s3uploader/internal/dbschema"
 )
 
 type DB struct {
@@ -18,15 +21,29 @@ type UploadRecord struct {
 	ClientID   string
 	RemotePath string
 	FileSize   int64
+	SHA256     *string
 	UploadedAt int64
 }
 
+// Report is one day of a client's activity, as sent to POST /report.
+type Report struct {
+	Day            string `json:"day"`
+	FilesUploaded  int    `json:"files_uploaded"`
+	BytesUploaded  int64  `json:"bytes_uploaded"`
+	FilesSkipped   int    `json:"files_skipped"`
+	FilesFailed    int    `json:"files_failed"`
+	QueueHighWater int    `json:"queue_high_water"`
+	ReceivedAt     int64  `json:"received_at"`
+}
+
 func NewDB(dbPath string) (*DB, error) {
 	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
 		return nil, err
 	}
 
-	db, err := sql.Open("sqlite", dbPath)
+	// Request handlers and the webhook dispatcher write concurrently, so
+	// wait on locks instead of failing with SQLITE_BUSY.
+	db, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)")
 	if err != nil {
 		return nil, err
 	}
@@ -46,23 +63,289 @@ func initServerSchema(db *sql.DB) error {
 			client_id TEXT NOT NULL,
 			remote_path TEXT NOT NULL,
 			file_size INTEGER NOT NULL,
-			uploaded_at INTEGER NOT NULL
+			uploaded_at INTEGER NOT NULL,
+			sha256 TEXT
 		);
 		CREATE INDEX IF NOT EXISTS idx_uploads_client_id ON uploads(client_id);
 		CREATE INDEX IF NOT EXISTS idx_uploads_remote_path ON uploads(remote_path);
+		CREATE INDEX IF NOT EXISTS idx_uploads_client_path_time ON uploads(client_id, remote_path, uploaded_at);
+		CREATE TABLE IF NOT EXISTS reports (
+			client_id TEXT NOT NULL,
+			day TEXT NOT NULL,
+			files_uploaded INTEGER NOT NULL,
+			bytes_uploaded INTEGER NOT NULL,
+			files_skipped INTEGER NOT NULL,
+			files_failed INTEGER NOT NULL,
+			queue_high_water INTEGER NOT NULL,
+			received_at INTEGER NOT NULL,
+			PRIMARY KEY (client_id, day)
+		);
+		CREATE TABLE IF NOT EXISTS clients (
+			client_id TEXT PRIMARY KEY,
+			hostname TEXT NOT NULL DEFAULT '',
+			version TEXT NOT NULL DEFAULT '',
+			first_seen INTEGER NOT NULL,
+			last_seen INTEGER NOT NULL,
+			last_report INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE TABLE IF NOT EXISTS webhook_outbox (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT NOT NULL,
+			event TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			dedup_key TEXT UNIQUE NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at INTEGER NOT NULL,
+			last_error TEXT,
+			created_at INTEGER NOT NULL,
+			delivered_at INTEGER
+		);
+		CREATE INDEX IF NOT EXISTS idx_webhook_outbox_next_attempt_at ON webhook_outbox(next_attempt_at);
+		CREATE TABLE IF NOT EXISTS alert_state (
+			client_id TEXT PRIMARY KEY,
+			state TEXT NOT NULL,
+			changed_at INTEGER NOT NULL
+		);
 	`
-	_, err := db.Exec(schema)
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+	return dbschema.AddColumnIfMissing(db, "uploads", "sha256", "TEXT")
+}
+
+func (d *DB) InsertUpload(clientID, remotePath string, fileSize int64, sha256 string) error {
+	var checksum *string
+	if sha256 != "" {
+		checksum = &sha256
+	}
+	_, err := d.db.Exec(`
+		INSERT INTO uploads (client_id, remote_path, file_size, uploaded_at, sha256)
+		VALUES (?, ?, ?, ?, ?)
+	`, clientID, remotePath, fileSize, time.Now().UTC().Unix(), checksum)
 	return err
 }
 
-func (d *DB) InsertUpload(clientID, remotePath string, fileSize int64) error {
+// LatestUploads returns, for the path prefix and each path below it, the
+// most recent upload at or before the unix time at. The prefix matches whole
+// path segments, so "a/b" covers "a/b/c" but not "a/bc". Uploads in the same
+// second are told apart by id.
+func (d *DB) LatestUploads(clientID, prefix string, at int64) ([]UploadRecord, error) {
+	dir := ""
+	if prefix != "" {
+		dir = strings.TrimSuffix(prefix, "/") + "/"
+	}
+	rows, err := d.db.Query(`
+		SELECT u.id, u.client_id, u.remote_path, u.file_size, u.sha256, u.uploaded_at
+		FROM uploads u
+		WHERE u.client_id = ? AND (u.remote_path = ? OR (u.remote_path >= ? AND u.remote_path < ?)) AND u.id = (
+			SELECT l.id FROM uploads l
+			WHERE l.client_id = u.client_id AND l.remote_path = u.remote_path AND l.uploaded_at <= ?
+			ORDER BY l.uploaded_at DESC, l.id DESC LIMIT 1
+		)
+		ORDER BY u.remote_path
+	`, clientID, prefix, dir, dbschema.PrefixEnd(dir), at)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []UploadRecord
+	for rows.Next() {
+		var rec UploadRecord
+		if err := rows.Scan(&rec.ID, &rec.ClientID, &rec.RemotePath, &rec.FileSize, &rec.SHA256, &rec.UploadedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// SaveReports stores daily reports from a client. A day that was already
+// reported is replaced, so a resent report is harmless.
+func (d *DB) SaveReports(clientID string, reports []Report) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Unix()
+	for _, r := range reports {
+		_, err := tx.Exec(`
+			INSERT OR REPLACE INTO reports (client_id, day, files_uploaded, bytes_uploaded, files_skipped, files_failed, queue_high_water, received_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, clientID, r.Day, r.FilesUploaded, r.BytesUploaded, r.FilesSkipped, r.FilesFailed, r.QueueHighWater, now)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListReports returns a client's reports, newest day first.
+func (d *DB) ListReports(clientID string) ([]Report, error) {
+	rows, err := d.db.Query(`
+		SELECT day, files_uploaded, bytes_uploaded, files_skipped, files_failed, queue_high_water, received_at
+		FROM reports WHERE client_id = ? ORDER BY day DESC
+	`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []Report
+	for rows.Next() {
+		var r Report
+		if err := rows.Scan(&r.Day, &r.FilesUploaded, &r.BytesUploaded, &r.FilesSkipped, &r.FilesFailed, &r.QueueHighWater, &r.ReceivedAt); err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+func (d *DB) SaveClientHealth(c *ClientHealth) error {
 	_, err := d.db.Exec(`
-		INSERT INTO uploads (client_id, remote_path, file_size, uploaded_at)
-		VALUES (?, ?, ?, ?)
-	`, clientID, remotePath, fileSize, time.Now().UTC().Unix())
+		INSERT OR REPLACE INTO clients (client_id, hostname, version, first_seen, last_seen, last_report)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, c.ID, c.Hostname, c.Version, c.FirstSeen, c.LastSeen, c.LastReport)
 	return err
 }
 
+func (d *DB) ListClientHealth() ([]ClientHealth, error) {
+	rows, err := d.db.Query(`SELECT client_id, hostname, version, first_seen, last_seen, last_report FROM clients`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []ClientHealth
+	for rows.Next() {
+		var c ClientHealth
+		if err := rows.Scan(&c.ID, &c.Hostname, &c.Version, &c.FirstSeen, &c.LastSeen, &c.LastReport); err != nil {
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+	return clients, rows.Err()
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertWebhook(db execer, e OutboxEntry) error {
+	now := time.Now().UTC().Unix()
+	_, err := db.Exec(`
+		INSERT OR IGNORE INTO webhook_outbox (url, event, payload, dedup_key, next_attempt_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, e.URL, e.Event, string(e.Payload), e.DedupKey, now, now)
+	return err
+}
+
+// EnqueueWebhooks adds deliveries to the outbox in one transaction,
+// skipping any whose dedup key is already there.
+func (d *DB) EnqueueWebhooks(entries []OutboxEntry) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, e := range entries {
+		if err := insertWebhook(tx, e); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DueWebhooks returns undelivered outbox entries that are due and have been
+// tried fewer than maxAttempts times, oldest first.
+func (d *DB) DueWebhooks(now int64, maxAttempts int) ([]OutboxEntry, error) {
+	rows, err := d.db.Query(`
+		SELECT id, url, event, payload, dedup_key, attempts, next_attempt_at FROM webhook_outbox
+		WHERE delivered_at IS NULL AND next_attempt_at <= ? AND attempts < ?
+		ORDER BY id
+	`, now, maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []OutboxEntry
+	for rows.Next() {
+		var e OutboxEntry
+		var payload string
+		if err := rows.Scan(&e.ID, &e.URL, &e.Event, &payload, &e.DedupKey, &e.Attempts, &e.NextAttemptAt); err != nil {
+			return nil, err
+		}
+		e.Payload = []byte(payload)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (d *DB) WebhookDelivered(id, at int64) error {
+	_, err := d.db.Exec(`UPDATE webhook_outbox SET delivered_at = ?, attempts = attempts + 1, last_error = NULL WHERE id = ?`, at, id)
+	return err
+}
+
+func (d *DB) WebhookFailed(id int64, attempts int, nextAttemptAt int64, lastError string) error {
+	_, err := d.db.Exec(`
+		UPDATE webhook_outbox SET attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?
+	`, attempts, nextAttemptAt, lastError, id)
+	return err
+}
+
+// PruneWebhooks deletes outbox entries delivered before the unix time
+// before, and entries created before it that were given up on after
+// maxAttempts, returning how many were deleted.
+func (d *DB) PruneWebhooks(before int64, maxAttempts int) (int64, error) {
+	res, err := d.db.Exec(`
+		DELETE FROM webhook_outbox
+		WHERE (delivered_at IS NOT NULL AND delivered_at < ?)
+			OR (delivered_at IS NULL AND attempts >= ? AND created_at < ?)
+	`, before, maxAttempts, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetAlertState returns the last alerted state of a client, or "" if it was
+// never checked.
+func (d *DB) GetAlertState(clientID string) (string, error) {
+	var state string
+	err := d.db.QueryRow(`SELECT state FROM alert_state WHERE client_id = ?`, clientID).Scan(&state)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return state, err
+}
+
+// SetAlertState records a client's new alert state and queues its alerts in
+// one transaction, so a state change is alerted exactly once.
+func (d *DB) SetAlertState(clientID, state string, at int64, alerts []OutboxEntry) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT OR REPLACE INTO alert_state (client_id, state, changed_at) VALUES (?, ?, ?)
+	`, clientID, state, at); err != nil {
+		return err
+	}
+	for _, e := range alerts {
+		if err := insertWebhook(tx, e); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func (d *DB) Close() error {
 	return d.db.Close()
 }
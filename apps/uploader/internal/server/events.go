@@ -0,0 +1,99 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+const (
+	EventFileUploaded   = "file.uploaded"
+	EventFileDownloaded = "file.downloaded"
+	EventPrefixDeleted  = "prefix.deleted"
+)
+
+func isFileEvent(event string) bool {
+	switch event {
+	case EventFileUploaded, EventFileDownloaded, EventPrefixDeleted:
+		return true
+	}
+	return false
+}
+
+// FileEvent is the payload of a file event webhook. ID is unique per
+// event, so receivers can drop retried deliveries they already handled.
+type FileEvent struct {
+	ID         string `json:"id"`
+	Event      string `json:"event"`
+	ClientID   string `json:"client_id"`
+	Path       string `json:"path,omitempty"`
+	Prefix     string `json:"prefix,omitempty"`
+	CopiedFrom string `json:"copied_from,omitempty"`
+	S3Key      string `json:"s3_key,omitempty"`
+	VersionID  string `json:"version_id,omitempty"`
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256,omitempty"`
+	Deleted    int    `json:"deleted,omitempty"`
+	At         int64  `json:"at"`
+}
+
+// SetWebhooks sets the endpoints that receive file events. Events are only
+// sent when the handler has a database to queue them in.
+func (h *Handler) SetWebhooks(endpoints []WebhookConfig) {
+	h.webhooks = endpoints
+}
+
+func subscribed(wh WebhookConfig, event string) bool {
+	if len(wh.Events) == 0 {
+		return true
+	}
+	for _, e := range wh.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// notify queues e for every endpoint subscribed to it. The request it
+// describes has already succeeded, so failures are only logged.
+func (h *Handler) notify(e FileEvent) {
+	if h.db == nil || len(h.webhooks) == 0 {
+		return
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		log.Printf("failed to queue %s webhook: %v", e.Event, err)
+		return
+	}
+	e.ID = hex.EncodeToString(id)
+	e.At = time.Now().UTC().Unix()
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("failed to queue %s webhook: %v", e.Event, err)
+		return
+	}
+
+	var entries []OutboxEntry
+	for _, wh := range h.webhooks {
+		if !subscribed(wh, e.Event) {
+			continue
+		}
+		entries = append(entries, OutboxEntry{
+			URL:      wh.URL,
+			Event:    e.Event,
+			Payload:  payload,
+			DedupKey: e.ID + ":" + wh.URL,
+		})
+	}
+	if len(entries) == 0 {
+		return
+	}
+	if err := h.db.EnqueueWebhooks(entries); err != nil {
+		log.Printf("failed to queue %s webhook: %v", e.Event, err)
+	}
+}
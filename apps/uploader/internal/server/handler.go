@@ -1,31 +1,105 @@
 package server
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Handler struct {
-	storage Storage
-	db      *DB
+	storage     Storage
+	db          *DB
+	maxVersions int
+	prunes      chan pruneRequest
+	health      *HealthRegistry
+	webhooks    []WebhookConfig
 }
 
 func NewHandler(storage Storage, db *DB) *Handler {
-	return &Handler{storage: storage, db: db}
+	return &Handler{storage: storage, db: db, health: NewHealthRegistry(db)}
+}
+
+func (h *Handler) Health() *HealthRegistry {
+	return h.health
+}
+
+// SetMaxVersions caps how many versions of each path are kept. Zero keeps
+// them all. Old versions are pruned in the background after each upload.
+func (h *Handler) SetMaxVersions(n int) {
+	h.maxVersions = n
+	if n > 0 && h.prunes == nil {
+		h.prunes = make(chan pruneRequest, 256)
+		go h.runPruner()
+	}
+}
+
+type pruneRequest struct {
+	clientID   string
+	remotePath string
+}
+
+// pruneTimeout bounds one background prune, so a stuck S3 request does not
+// hold up the ones queued behind it.
+const pruneTimeout = 5 * time.Minute
+
+// pruneVersions queues remotePath for pruning after a new version of it was
+// stored. The request has already succeeded, so when the queue is full the
+// path is skipped; its next upload prunes it again.
+func (h *Handler) pruneVersions(clientID, remotePath string) {
+	if h.maxVersions <= 0 {
+		return
+	}
+	select {
+	case h.prunes <- pruneRequest{clientID: clientID, remotePath: remotePath}:
+	default:
+		log.Printf("version pruning is behind, skipping %s", remotePath)
+	}
+}
+
+func (h *Handler) runPruner() {
+	for req := range h.prunes {
+		ctx, cancel := context.WithTimeout(context.Background(), pruneTimeout)
+		if _, err := h.storage.PruneVersions(ctx, req.clientID, req.remotePath, h.maxVersions); err != nil {
+			log.Printf("failed to prune versions of %s: %v", req.remotePath, err)
+		}
+		cancel()
+	}
 }
 
 func (h *Handler) RegisterRoutes(mux *http.ServeMux, auth *AuthMiddleware) {
+	protect := func(f http.HandlerFunc) http.Handler {
+		return auth.Wrap(h.track(f))
+	}
+
 	mux.HandleFunc("/health", h.handleHealth)
-	mux.Handle("/upload", auth.Wrap(http.HandlerFunc(h.handleUpload)))
-	mux.Handle("/exists", auth.Wrap(http.HandlerFunc(h.handleExists)))
-	mux.Handle("/download", auth.Wrap(http.HandlerFunc(h.handleDownload)))
-	mux.Handle("/delete-prefix", auth.Wrap(http.HandlerFunc(h.handleDeletePrefix)))
-	mux.Handle("/list", auth.Wrap(http.HandlerFunc(h.handleList)))
+	mux.Handle("/upload", protect(h.handleUpload))
+	mux.Handle("/exists", protect(h.handleExists))
+	mux.Handle("/download", protect(h.handleDownload))
+	mux.Handle("/versions", protect(h.handleVersions))
+	mux.Handle("/history", protect(h.handleHistory))
+	mux.Handle("/delete", protect(h.handleDelete))
+	mux.Handle("/copy", protect(h.handleCopy))
+	mux.Handle("/delete-prefix", protect(h.handleDeletePrefix))
+	mux.Handle("/list", protect(h.handleList))
+	mux.Handle("/report", protect(h.handleReport))
+	mux.Handle("/heartbeat", protect(h.handleHeartbeat))
+	mux.Handle("/multipart/init", protect(h.handleMultipartInit))
+	mux.Handle("/multipart/part", protect(h.handleMultipartPart))
+	mux.Handle("/multipart/complete", protect(h.handleMultipartComplete))
+	mux.Handle("/multipart/abort", protect(h.handleMultipartAbort))
 }
 
 func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -44,19 +118,50 @@ func (h *Handler) handleUpload(w http.ResponseWriter, r *http.Request) {
 	}
 	clientID := GetClientID(r.Context())
 
-	if err := r.ParseMultipartForm(100 << 20); err != nil {
+	mr, err := r.MultipartReader()
+	if err != nil {
 		http.Error(w, "failed to parse multipart form", http.StatusBadRequest)
 		return
 	}
 
-	file, header, err := r.FormFile("file")
-	if err != nil {
+	var remotePath, sizeField, checksum string
+	var file *multipart.Part
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "failed to parse multipart form", http.StatusBadRequest)
+			return
+		}
+
+		if part.FormName() == "file" {
+			file = part
+			break
+		}
+
+		value, err := readFormValue(part)
+		if err != nil {
+			http.Error(w, "failed to read form field", http.StatusBadRequest)
+			return
+		}
+		switch part.FormName() {
+		case "path":
+			remotePath = value
+		case "size":
+			sizeField = value
+		case "sha256":
+			checksum = strings.ToLower(value)
+		}
+	}
+
+	if file == nil {
 		http.Error(w, "missing file field", http.StatusBadRequest)
 		return
 	}
 	defer file.Close()
 
-	remotePath := r.FormValue("path")
 	if remotePath == "" {
 		http.Error(w, "missing path field", http.StatusBadRequest)
 		return
@@ -67,26 +172,188 @@ func (h *Handler) handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s3Key, err := h.storage.Upload(r.Context(), clientID, remotePath, file, header.Size)
+	if checksum != "" && !isValidSHA256(checksum) {
+		http.Error(w, "invalid sha256 field", http.StatusBadRequest)
+		return
+	}
+
+	// Storage needs the length before the body. Clients that do not send
+	// it get their file spooled to disk first.
+	var src io.Reader = file
+	var size int64
+	if sizeField == "" {
+		spool, n, err := spoolToTempFile(file)
+		if err != nil {
+			http.Error(w, "upload failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		src, size = spool, n
+	} else {
+		size, err = strconv.ParseInt(sizeField, 10, 64)
+		if err != nil || size < 0 {
+			http.Error(w, "invalid size field", http.StatusBadRequest)
+			return
+		}
+	}
+
+	body := newSizedReader(src, size, checksum)
+	s3Key, err := h.storage.Upload(r.Context(), clientID, remotePath, body, size, checksum)
+	if err == nil {
+		err = body.finish()
+	}
 	if err != nil {
-		http.Error(w, "upload failed: "+err.Error(), http.StatusInternalServerError)
+		writeUploadError(w, "upload", body, err)
 		return
 	}
 
 	if h.db != nil {
-		if dbErr := h.db.InsertUpload(clientID, remotePath, header.Size); dbErr != nil {
+		if dbErr := h.db.InsertUpload(clientID, remotePath, size, body.Sum()); dbErr != nil {
 			log.Printf("failed to record upload in database: %v", dbErr)
 		}
 	}
+	h.pruneVersions(clientID, remotePath)
+	h.notify(FileEvent{Event: EventFileUploaded, ClientID: clientID, Path: remotePath, S3Key: s3Key, Size: size, SHA256: body.Sum()})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
 		"s3_key":  s3Key,
-		"size":    header.Size,
+		"size":    size,
+		"sha256":  body.Sum(),
 	})
 }
 
+const maxFormValueSize = 4096
+
+// spoolToTempFile copies r to a temporary file and returns it rewound,
+// with its length. The caller removes the file.
+func spoolToTempFile(r io.Reader) (*os.File, int64, error) {
+	f, err := os.CreateTemp("", "s3up-upload-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := io.Copy(f, r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, err
+	}
+	return f, n, nil
+}
+
+func readFormValue(part *multipart.Part) (string, error) {
+	defer part.Close()
+	data, err := io.ReadAll(io.LimitReader(part, maxFormValueSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxFormValueSize {
+		return "", errors.New("form value too large")
+	}
+	return string(data), nil
+}
+
+// sizedReader passes through exactly the declared number of bytes of an
+// upload. The final read fails if the part turns out to be shorter or
+// longer, or if it does not match a declared SHA-256 digest, so the storage
+// backend never commits the object.
+type sizedReader struct {
+	r         io.Reader
+	remaining int64
+	checksum  string
+	hash      hash.Hash
+	mismatch  bool
+	ended     bool
+	endErr    error
+}
+
+func newSizedReader(r io.Reader, size int64, checksum string) *sizedReader {
+	return &sizedReader{r: r, remaining: size, checksum: checksum, hash: sha256.New()}
+}
+
+func (s *sizedReader) Read(p []byte) (int, error) {
+	if s.remaining <= 0 {
+		if err := s.end(); err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.r.Read(p)
+	s.hash.Write(p[:n])
+	s.remaining -= int64(n)
+	if err == io.EOF && s.remaining > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+	if s.remaining == 0 {
+		if verr := s.end(); verr != nil {
+			return n, verr
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// end runs once the declared size has been read: it fails if more data
+// follows or the digest does not match.
+func (s *sizedReader) end() error {
+	if !s.ended {
+		s.ended = true
+		var extra [1]byte
+		if n, _ := io.ReadFull(s.r, extra[:]); n > 0 {
+			s.endErr = errors.New("body larger than declared size")
+		} else {
+			s.endErr = s.verify()
+		}
+	}
+	return s.endErr
+}
+
+func (s *sizedReader) verify() error {
+	if s.checksum != "" && hex.EncodeToString(s.hash.Sum(nil)) != s.checksum {
+		s.mismatch = true
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// finish checks, for a backend that stopped reading without an error, that
+// the body was fully consumed and nothing follows it.
+func (s *sizedReader) finish() error {
+	if s.remaining > 0 {
+		return fmt.Errorf("body shorter than declared size by %d bytes", s.remaining)
+	}
+	return s.end()
+}
+
+// Sum returns the hex SHA-256 of everything read so far.
+func (s *sizedReader) Sum() string {
+	return hex.EncodeToString(s.hash.Sum(nil))
+}
+
+// writeUploadError reports a failed storage write, using 422 for checksum
+// mismatches so clients can tell them apart and retry.
+func writeUploadError(w http.ResponseWriter, op string, body *sizedReader, err error) {
+	if body.mismatch || errors.Is(err, ErrChecksumMismatch) {
+		http.Error(w, op+" failed: "+ErrChecksumMismatch.Error()+": received data does not match sha256", http.StatusUnprocessableEntity)
+		return
+	}
+	if os.IsNotExist(err) {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, op+" failed: "+err.Error(), http.StatusInternalServerError)
+}
+
 func (h *Handler) handleExists(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -105,14 +372,41 @@ func (h *Handler) handleExists(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	exists, err := h.storage.Exists(r.Context(), clientID, remotePath)
+	exists, size, err := h.storage.Exists(r.Context(), clientID, remotePath)
 	if err != nil {
 		http.Error(w, "check failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	resp := map[string]interface{}{"exists": exists}
+	if exists {
+		resp["size"] = size
+		if checksum := h.recordedChecksum(clientID, remotePath, size); checksum != "" {
+			resp["sha256"] = checksum
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"exists": exists})
+	json.NewEncoder(w).Encode(resp)
+}
+
+// recordedChecksum returns the sha256 of the latest recorded upload of
+// remotePath if its size matches the stored object, or "" when unknown.
+func (h *Handler) recordedChecksum(clientID, remotePath string, size int64) string {
+	if h.db == nil {
+		return ""
+	}
+	records, err := h.db.LatestUploads(clientID, remotePath, time.Now().UTC().Unix())
+	if err != nil {
+		log.Printf("failed to look up upload of %s: %v", remotePath, err)
+		return ""
+	}
+	for _, rec := range records {
+		if rec.RemotePath == remotePath && rec.FileSize == size && rec.SHA256 != nil {
+			return *rec.SHA256
+		}
+	}
+	return ""
 }
 
 func (h *Handler) handleDownload(w http.ResponseWriter, r *http.Request) {
@@ -133,7 +427,15 @@ func (h *Handler) handleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	body, contentType, err := h.storage.Download(r.Context(), clientID, remotePath)
+	var body io.ReadCloser
+	var contentType string
+	var err error
+	versionID := r.URL.Query().Get("version_id")
+	if versionID != "" {
+		body, contentType, err = h.storage.DownloadVersion(r.Context(), clientID, remotePath, versionID)
+	} else {
+		body, contentType, err = h.storage.Download(r.Context(), clientID, remotePath)
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
 			http.Error(w, "file not found", http.StatusNotFound)
@@ -145,7 +447,224 @@ func (h *Handler) handleDownload(w http.ResponseWriter, r *http.Request) {
 	defer body.Close()
 
 	w.Header().Set("Content-Type", contentType)
-	io.Copy(w, body)
+	n, err := io.Copy(w, body)
+	if err != nil {
+		return
+	}
+	h.notify(FileEvent{Event: EventFileDownloaded, ClientID: clientID, Path: remotePath, VersionID: versionID, Size: n})
+}
+
+func (h *Handler) handleVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := GetClientID(r.Context())
+	query := r.URL.Query()
+
+	var versions []ObjectVersion
+	var err error
+	if query.Has("prefix") {
+		prefix := query.Get("prefix")
+		if prefix != "" && !isValidPath(prefix) {
+			http.Error(w, "invalid prefix", http.StatusBadRequest)
+			return
+		}
+		versions, err = h.storage.ListPrefixVersions(r.Context(), clientID, prefix)
+	} else {
+		remotePath := query.Get("path")
+		if remotePath == "" {
+			http.Error(w, "missing path parameter", http.StatusBadRequest)
+			return
+		}
+		if !isValidPath(remotePath) {
+			http.Error(w, "invalid path", http.StatusBadRequest)
+			return
+		}
+		versions, err = h.storage.ListVersions(r.Context(), clientID, remotePath)
+	}
+	if err != nil {
+		http.Error(w, "list versions failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if versions == nil {
+		versions = []ObjectVersion{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"versions": versions,
+	})
+}
+
+type historyEntry struct {
+	Path       string  `json:"path"`
+	Size       int64   `json:"size"`
+	SHA256     *string `json:"sha256"`
+	UploadedAt int64   `json:"uploaded_at"`
+}
+
+// handleHistory lists every path under prefix that was uploaded at or
+// before the unix time in "at" (default now), with its latest upload. The
+// list is empty when the server runs without a database.
+func (h *Handler) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := GetClientID(r.Context())
+
+	prefix := r.URL.Query().Get("prefix")
+	if prefix != "" && !isValidPath(prefix) {
+		http.Error(w, "invalid prefix", http.StatusBadRequest)
+		return
+	}
+
+	at := time.Now().UTC().Unix()
+	if atField := r.URL.Query().Get("at"); atField != "" {
+		var err error
+		at, err = strconv.ParseInt(atField, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid at parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries := []historyEntry{}
+	if h.db != nil {
+		records, err := h.db.LatestUploads(clientID, prefix, at)
+		if err != nil {
+			http.Error(w, "history failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, rec := range records {
+			entries = append(entries, historyEntry{
+				Path:       rec.RemotePath,
+				Size:       rec.FileSize,
+				SHA256:     rec.SHA256,
+				UploadedAt: rec.UploadedAt,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files": entries,
+	})
+}
+
+func (h *Handler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := GetClientID(r.Context())
+
+	remotePath := r.FormValue("path")
+	if remotePath == "" {
+		http.Error(w, "missing path field", http.StatusBadRequest)
+		return
+	}
+
+	if !isValidPath(remotePath) {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.storage.Delete(r.Context(), clientID, remotePath); err != nil {
+		http.Error(w, "delete failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// handleCopy copies an object the client already uploaded to a new path, so
+// a renamed file does not have to be uploaded again. The optional size field
+// must match the source; the size recorded is always that of the copy. The
+// optional sha256 field is recorded as is.
+func (h *Handler) handleCopy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := GetClientID(r.Context())
+
+	from := r.FormValue("from")
+	to := r.FormValue("to")
+	if from == "" || to == "" {
+		http.Error(w, "missing from or to field", http.StatusBadRequest)
+		return
+	}
+
+	if !isValidPath(from) || !isValidPath(to) {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	var size int64
+	if sizeField := r.FormValue("size"); sizeField != "" {
+		var err error
+		size, err = strconv.ParseInt(sizeField, 10, 64)
+		if err != nil || size < 0 {
+			http.Error(w, "invalid size field", http.StatusBadRequest)
+			return
+		}
+	}
+
+	checksum := strings.ToLower(r.FormValue("sha256"))
+	if checksum != "" && !isValidSHA256(checksum) {
+		http.Error(w, "invalid sha256 field", http.StatusBadRequest)
+		return
+	}
+
+	// The copy is recorded with its source's checksum. The client's value
+	// is only checked against it, so /exists never echoes an unverified
+	// digest back to cleanup.
+	var recorded string
+	if size > 0 {
+		recorded = h.recordedChecksum(clientID, from, size)
+		if checksum != "" && recorded != "" && checksum != recorded {
+			http.Error(w, "copy failed: sha256 does not match the source", http.StatusConflict)
+			return
+		}
+	}
+
+	s3Key, copied, err := h.storage.Copy(r.Context(), clientID, from, to, size)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "file not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, ErrSizeMismatch) {
+			http.Error(w, "copy failed: "+err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "copy failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if size == 0 {
+		recorded = h.recordedChecksum(clientID, from, copied)
+	}
+	size, checksum = copied, recorded
+
+	if h.db != nil {
+		if dbErr := h.db.InsertUpload(clientID, to, size, checksum); dbErr != nil {
+			log.Printf("failed to record upload in database: %v", dbErr)
+		}
+	}
+	h.pruneVersions(clientID, to)
+	h.notify(FileEvent{Event: EventFileUploaded, ClientID: clientID, Path: to, CopiedFrom: from, S3Key: s3Key, Size: size, SHA256: checksum})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"s3_key":  s3Key,
+		"size":    size,
+		"sha256":  checksum,
+	})
 }
 
 func (h *Handler) handleDeletePrefix(w http.ResponseWriter, r *http.Request) {
@@ -166,9 +685,14 @@ func (h *Handler) handleDeletePrefix(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A partial failure still deleted some objects, which receivers should
+	// hear about.
 	deleted, err := h.storage.DeletePrefix(r.Context(), clientID, prefix)
+	if deleted > 0 {
+		h.notify(FileEvent{Event: EventPrefixDeleted, ClientID: clientID, Prefix: prefix, Deleted: deleted})
+	}
 	if err != nil {
-		http.Error(w, "delete failed: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("delete failed after deleting %d objects: %v", deleted, err), http.StatusInternalServerError)
 		return
 	}
 
@@ -208,6 +732,260 @@ func (h *Handler) handleList(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// maxReportsPerRequest bounds a catch-up batch from a client that was down
+// for a long time.
+const maxReportsPerRequest = 366
+
+func (h *Handler) handleReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := GetClientID(r.Context())
+
+	var req struct {
+		Reports []Report `json:"reports"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Reports) == 0 || len(req.Reports) > maxReportsPerRequest {
+		http.Error(w, fmt.Sprintf("expected 1 to %d reports", maxReportsPerRequest), http.StatusBadRequest)
+		return
+	}
+	for _, report := range req.Reports {
+		if _, err := time.Parse("2006-01-02", report.Day); err != nil {
+			http.Error(w, "invalid day "+strconv.Quote(report.Day), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if h.db != nil {
+		if err := h.db.SaveReports(clientID, req.Reports); err != nil {
+			http.Error(w, "report failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	h.health.Reported(clientID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"accepted": len(req.Reports),
+	})
+}
+
+// maxPartNumber is the S3 limit on the number of parts in one upload.
+const maxPartNumber = 10000
+
+func (h *Handler) handleMultipartInit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := GetClientID(r.Context())
+
+	remotePath := r.FormValue("path")
+	if remotePath == "" {
+		http.Error(w, "missing path field", http.StatusBadRequest)
+		return
+	}
+
+	if !isValidPath(remotePath) {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	uploadID, err := h.storage.CreateMultipartUpload(r.Context(), clientID, remotePath)
+	if err != nil {
+		http.Error(w, "init failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"upload_id": uploadID})
+}
+
+func (h *Handler) handleMultipartPart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := GetClientID(r.Context())
+
+	query := r.URL.Query()
+	remotePath := query.Get("path")
+	uploadID := query.Get("upload_id")
+	if remotePath == "" || uploadID == "" {
+		http.Error(w, "missing path or upload_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	if !isValidPath(remotePath) {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	partNumber, err := strconv.Atoi(query.Get("part_number"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		http.Error(w, "invalid part_number parameter", http.StatusBadRequest)
+		return
+	}
+
+	checksum := strings.ToLower(query.Get("sha256"))
+	if !isValidSHA256(checksum) {
+		http.Error(w, "missing or invalid sha256 parameter", http.StatusBadRequest)
+		return
+	}
+
+	if r.ContentLength < 0 {
+		http.Error(w, "content length required", http.StatusLengthRequired)
+		return
+	}
+
+	body := newSizedReader(r.Body, r.ContentLength, checksum)
+	etag, err := h.storage.UploadPart(r.Context(), clientID, remotePath, uploadID, int32(partNumber), body, r.ContentLength, checksum)
+	if err == nil {
+		err = body.finish()
+	}
+	if err != nil {
+		writeUploadError(w, "part upload", body, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"etag": etag})
+}
+
+// multipartCompleteRequest carries the client's size and whole-file sha256
+// along with the parts. The client computes the digest in the same pass in
+// which it checks every part's digest again, so it describes the bytes the
+// verified parts hold.
+type multipartCompleteRequest struct {
+	Path     string          `json:"path"`
+	UploadID string          `json:"upload_id"`
+	Size     int64           `json:"size"`
+	SHA256   string          `json:"sha256"`
+	Parts    []CompletedPart `json:"parts"`
+}
+
+func (h *Handler) handleMultipartComplete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := GetClientID(r.Context())
+
+	var req multipartCompleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Path == "" || req.UploadID == "" {
+		http.Error(w, "missing path or upload_id field", http.StatusBadRequest)
+		return
+	}
+
+	if !isValidPath(req.Path) {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Parts) == 0 {
+		http.Error(w, "missing parts field", http.StatusBadRequest)
+		return
+	}
+
+	partsVerified := true
+	for i := range req.Parts {
+		req.Parts[i].SHA256 = strings.ToLower(req.Parts[i].SHA256)
+		if req.Parts[i].SHA256 != "" && !isValidSHA256(req.Parts[i].SHA256) {
+			http.Error(w, "invalid part sha256", http.StatusBadRequest)
+			return
+		}
+		partsVerified = partsVerified && req.Parts[i].SHA256 != ""
+	}
+
+	req.SHA256 = strings.ToLower(req.SHA256)
+	if req.SHA256 != "" && !isValidSHA256(req.SHA256) {
+		http.Error(w, "invalid sha256", http.StatusBadRequest)
+		return
+	}
+
+	s3Key, size, err := h.storage.CompleteMultipartUpload(r.Context(), clientID, req.Path, req.UploadID, req.Parts)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "upload not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, ErrChecksumMismatch) {
+			http.Error(w, "complete failed: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		http.Error(w, "complete failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Each part's digest was verified as it arrived and again by storage on
+	// completion. The whole-file digest is only recorded if all parts had
+	// one and the client's size matches the assembled object.
+	checksum := ""
+	if partsVerified && req.Size == size {
+		checksum = req.SHA256
+	} else if req.SHA256 != "" {
+		log.Printf("not recording sha256 of %s: parts without digests or size %d differs from stored %d", req.Path, req.Size, size)
+	}
+	if h.db != nil {
+		if dbErr := h.db.InsertUpload(clientID, req.Path, size, checksum); dbErr != nil {
+			log.Printf("failed to record upload in database: %v", dbErr)
+		}
+	}
+	h.pruneVersions(clientID, req.Path)
+	h.notify(FileEvent{Event: EventFileUploaded, ClientID: clientID, Path: req.Path, S3Key: s3Key, Size: size, SHA256: checksum})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"s3_key":  s3Key,
+		"size":    size,
+		"sha256":  checksum,
+	})
+}
+
+func (h *Handler) handleMultipartAbort(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := GetClientID(r.Context())
+
+	remotePath := r.FormValue("path")
+	uploadID := r.FormValue("upload_id")
+	if remotePath == "" || uploadID == "" {
+		http.Error(w, "missing path or upload_id field", http.StatusBadRequest)
+		return
+	}
+
+	if !isValidPath(remotePath) {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.storage.AbortMultipartUpload(r.Context(), clientID, remotePath, uploadID); err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "upload not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "abort failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
 func isValidPath(p string) bool {
 	if strings.Contains(p, "..") {
 		return false
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ClientHealthy   = "healthy"
+	ClientStale     = "stale"
+	ClientNeverSeen = "never_seen"
+)
+
+// Headers a client sends with every request to identify its host and build.
+const (
+	HostnameHeader = "X-Client-Hostname"
+	VersionHeader  = "X-Client-Version"
+)
+
+// ClientHealth is what the server knows about a client's activity. Times
+// are unix seconds, zero if it never happened.
+type ClientHealth struct {
+	ID         string `json:"id"`
+	Status     string `json:"status"`
+	Hostname   string `json:"hostname"`
+	Version    string `json:"version"`
+	FirstSeen  int64  `json:"first_seen"`
+	LastSeen   int64  `json:"last_seen"`
+	LastReport int64  `json:"last_report"`
+}
+
+// seenSaveInterval limits how often a busy client's last-seen time is
+// written to the database.
+const seenSaveInterval = time.Minute
+
+// HealthRegistry tracks when each client was last seen and last sent a
+// daily report, in memory and, when the server has a database, in the
+// clients table so it survives restarts.
+type HealthRegistry struct {
+	mu      sync.Mutex
+	db      *DB
+	clients map[string]*ClientHealth
+	saved   map[string]int64
+
+	staleAfter       time.Duration
+	reportStaleAfter time.Duration
+}
+
+func NewHealthRegistry(db *DB) *HealthRegistry {
+	r := &HealthRegistry{
+		db:               db,
+		clients:          make(map[string]*ClientHealth),
+		saved:            make(map[string]int64),
+		staleAfter:       30 * time.Minute,
+		reportStaleAfter: 36 * time.Hour,
+	}
+	if db != nil {
+		records, err := db.ListClientHealth()
+		if err != nil {
+			log.Printf("failed to load client health: %v", err)
+		}
+		for i := range records {
+			r.clients[records[i].ID] = &records[i]
+			r.saved[records[i].ID] = records[i].LastSeen
+		}
+	}
+	return r
+}
+
+// SetThresholds sets how long a client may go without a request, and
+// without a daily report, before it counts as stale. Zero keeps the
+// default.
+func (r *HealthRegistry) SetThresholds(staleAfter, reportStaleAfter time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if staleAfter > 0 {
+		r.staleAfter = staleAfter
+	}
+	if reportStaleAfter > 0 {
+		r.reportStaleAfter = reportStaleAfter
+	}
+}
+
+func (r *HealthRegistry) get(clientID string, now int64) *ClientHealth {
+	c, ok := r.clients[clientID]
+	if !ok {
+		c = &ClientHealth{ID: clientID, FirstSeen: now}
+		r.clients[clientID] = c
+	}
+	return c
+}
+
+// save writes c through to the database. Unless force is set, a client
+// that was saved recently is skipped.
+func (r *HealthRegistry) save(c *ClientHealth, force bool) {
+	if r.db == nil {
+		return
+	}
+	if !force && c.LastSeen-r.saved[c.ID] < int64(seenSaveInterval/time.Second) {
+		return
+	}
+	if err := r.db.SaveClientHealth(c); err != nil {
+		log.Printf("failed to save client health for %s: %v", c.ID, err)
+		return
+	}
+	r.saved[c.ID] = c.LastSeen
+}
+
+// Seen records an authenticated request from clientID.
+func (r *HealthRegistry) Seen(clientID, hostname, version string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC().Unix()
+	c := r.get(clientID, now)
+	changed := c.LastSeen == 0
+	if hostname != "" && hostname != c.Hostname {
+		c.Hostname = hostname
+		changed = true
+	}
+	if version != "" && version != c.Version {
+		c.Version = version
+		changed = true
+	}
+	c.LastSeen = now
+	r.save(c, changed)
+}
+
+// Reported records that clientID sent its daily report.
+func (r *HealthRegistry) Reported(clientID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC().Unix()
+	c := r.get(clientID, now)
+	c.LastReport = now
+	if c.LastSeen == 0 {
+		c.LastSeen = now
+	}
+	r.save(c, true)
+}
+
+// status decides health from the last request and the last report. A
+// client that has never reported is judged from when it was first seen.
+func (r *HealthRegistry) status(c *ClientHealth, now time.Time) string {
+	if c.LastSeen == 0 {
+		return ClientNeverSeen
+	}
+	if now.Sub(time.Unix(c.LastSeen, 0)) > r.staleAfter {
+		return ClientStale
+	}
+	lastReport := c.LastReport
+	if lastReport == 0 {
+		lastReport = c.FirstSeen
+	}
+	if now.Sub(time.Unix(lastReport, 0)) > r.reportStaleAfter {
+		return ClientStale
+	}
+	return ClientHealthy
+}
+
+// Statuses returns the health of each of the given clients, sorted by ID.
+func (r *HealthRegistry) Statuses(clientIDs []string) []ClientHealth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	result := make([]ClientHealth, 0, len(clientIDs))
+	for _, id := range clientIDs {
+		c := ClientHealth{ID: id}
+		if known, ok := r.clients[id]; ok {
+			c = *known
+		}
+		c.Status = r.status(&c, now)
+		result = append(result, c)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// track records every authenticated request in the health registry.
+func (h *Handler) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.health.Seen(GetClientID(r.Context()), r.Header.Get(HostnameHeader), r.Header.Get(VersionHeader))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Handler) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// RegisterAdminRoutes adds the admin endpoints. auth supplies the list of
+// configured clients.
+func (h *Handler) RegisterAdminRoutes(mux *http.ServeMux, admin *AdminAuth, auth *AuthMiddleware) {
+	mux.Handle("/clients", admin.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"clients": h.health.Statuses(auth.ClientIDs()),
+		})
+	})))
+}
@@ -3,15 +3,22 @@ package server
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"log"
+	"net/url"
 	"os"
 	"path"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 )
 
 type ListEntry struct {
@@ -19,12 +26,50 @@ type ListEntry struct {
 	Size int64  `json:"size"`
 }
 
+// ObjectVersion is one stored version of an object, newest first in
+// listings.
+type ObjectVersion struct {
+	// Path is only set in listings that span several paths.
+	Path         string    `json:"path,omitempty"`
+	VersionID    string    `json:"version_id"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	IsLatest     bool      `json:"is_latest"`
+	DeleteMarker bool      `json:"delete_marker,omitempty"`
+}
+
+type CompletedPart struct {
+	PartNumber int32  `json:"part_number"`
+	ETag       string `json:"etag"`
+	SHA256     string `json:"sha256"`
+}
+
 type Storage interface {
-	Upload(ctx context.Context, clientID, remotePath string, body io.Reader, size int64) (string, error)
-	Exists(ctx context.Context, clientID, remotePath string) (bool, error)
+	Upload(ctx context.Context, clientID, remotePath string, body io.Reader, size int64, checksum string) (string, error)
+	// Exists reports whether remotePath is stored, and its size if so.
+	Exists(ctx context.Context, clientID, remotePath string) (bool, int64, error)
 	Download(ctx context.Context, clientID, remotePath string) (io.ReadCloser, string, error)
+	Delete(ctx context.Context, clientID, remotePath string) error
+	// Copy returns the key and size of the new object. A positive size must
+	// match the source, or nothing is copied and ErrSizeMismatch returned.
+	Copy(ctx context.Context, clientID, srcPath, dstPath string, size int64) (string, int64, error)
 	DeletePrefix(ctx context.Context, clientID, prefix string) (int, error)
 	List(ctx context.Context, clientID, prefix string) ([]ListEntry, error)
+
+	ListVersions(ctx context.Context, clientID, remotePath string) ([]ObjectVersion, error)
+	// ListPrefixVersions lists the versions of every path under prefix,
+	// ordered by path and newest first within a path.
+	ListPrefixVersions(ctx context.Context, clientID, prefix string) ([]ObjectVersion, error)
+	DownloadVersion(ctx context.Context, clientID, remotePath, versionID string) (io.ReadCloser, string, error)
+	PruneVersions(ctx context.Context, clientID, remotePath string, keep int) (int, error)
+
+	CreateMultipartUpload(ctx context.Context, clientID, remotePath string) (string, error)
+	UploadPart(ctx context.Context, clientID, remotePath, uploadID string, partNumber int32, body io.Reader, size int64, checksum string) (string, error)
+	// CompleteMultipartUpload returns the key and size of the assembled
+	// object. Parts with a SHA256 must match the digest they were uploaded
+	// with.
+	CompleteMultipartUpload(ctx context.Context, clientID, remotePath, uploadID string, parts []CompletedPart) (string, int64, error)
+	AbortMultipartUpload(ctx context.Context, clientID, remotePath, uploadID string) error
 }
 
 type S3Client struct {
@@ -61,46 +106,55 @@ func NewS3Client(cfg S3Config) *S3Client {
 	}
 }
 
+// unsignedPayload lets PutObject and UploadPart stream a body that cannot
+// be seeked. The signer would otherwise read the body to hash it, which
+// the SDK only skips by itself over TLS. Integrity still comes from the
+// signed x-amz-checksum-sha256 header when the client sent a digest.
+func unsignedPayload(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware)
+}
+
 func (c *S3Client) buildKey(clientID, remotePath string) string {
 	return path.Join(c.pathPrefix, clientID, remotePath)
 }
 
-func (c *S3Client) Upload(ctx context.Context, clientID, remotePath string, body io.Reader, size int64) (string, error) {
+func (c *S3Client) Upload(ctx context.Context, clientID, remotePath string, body io.Reader, size int64, checksum string) (string, error) {
 	key := c.buildKey(clientID, remotePath)
 
 	_, err := c.client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:        aws.String(c.bucket),
-		Key:           aws.String(key),
-		Body:          body,
-		ContentLength: aws.Int64(size),
-	})
+		Bucket:         aws.String(c.bucket),
+		Key:            aws.String(key),
+		Body:           body,
+		ContentLength:  aws.Int64(size),
+		ChecksumSHA256: sha256Base64(checksum),
+	}, unsignedPayload)
 	if err != nil {
-		return "", err
+		return "", mapChecksumError(err)
 	}
 
 	return key, nil
 }
 
-func (c *S3Client) Exists(ctx context.Context, clientID, remotePath string) (bool, error) {
+func (c *S3Client) Exists(ctx context.Context, clientID, remotePath string) (bool, int64, error) {
 	key := c.buildKey(clientID, remotePath)
 
-	_, err := c.client.HeadObject(ctx, &s3.HeadObjectInput{
+	head, err := c.client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(c.bucket),
 		Key:    aws.String(key),
 	})
 	if err != nil {
 		var notFound *types.NotFound
 		if errors.As(err, &notFound) {
-			return false, nil
+			return false, 0, nil
 		}
 		var noSuchKey *types.NoSuchKey
 		if errors.As(err, &noSuchKey) {
-			return false, nil
+			return false, 0, nil
 		}
-		return false, err
+		return false, 0, err
 	}
 
-	return true, nil
+	return true, aws.ToInt64(head.ContentLength), nil
 }
 
 func (c *S3Client) Download(ctx context.Context, clientID, remotePath string) (io.ReadCloser, string, error) {
@@ -126,6 +180,119 @@ func (c *S3Client) Download(ctx context.Context, clientID, remotePath string) (i
 	return result.Body, contentType, nil
 }
 
+func (c *S3Client) Delete(ctx context.Context, clientID, remotePath string) error {
+	key := c.buildKey(clientID, remotePath)
+
+	_, err := c.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// maxCopyObjectSize is the largest object CopyObject accepts; larger ones
+// are copied part by part.
+const maxCopyObjectSize = 5 << 30
+
+// copyPartSize keeps a multipart copy of the largest S3 object (5 TB) under
+// the 10,000 part limit.
+const copyPartSize = 1 << 30
+
+func (c *S3Client) Copy(ctx context.Context, clientID, srcPath, dstPath string, size int64) (string, int64, error) {
+	srcKey := c.buildKey(clientID, srcPath)
+	dstKey := c.buildKey(clientID, dstPath)
+	copySource := url.PathEscape(c.bucket + "/" + srcKey)
+
+	head, err := c.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(srcKey),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &notFound) || errors.As(err, &noSuchKey) {
+			return "", 0, os.ErrNotExist
+		}
+		return "", 0, err
+	}
+	srcSize := aws.ToInt64(head.ContentLength)
+	if size > 0 && size != srcSize {
+		return "", 0, ErrSizeMismatch
+	}
+	size = srcSize
+
+	if size > maxCopyObjectSize {
+		if err := c.multipartCopy(ctx, copySource, dstKey, size); err != nil {
+			return "", 0, err
+		}
+		return dstKey, size, nil
+	}
+
+	_, err = c.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(c.bucket),
+		Key:               aws.String(dstKey),
+		CopySource:        aws.String(copySource),
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return "", 0, os.ErrNotExist
+		}
+		return "", 0, err
+	}
+
+	return dstKey, size, nil
+}
+
+// multipartCopy copies an object too large for CopyObject with UploadPartCopy
+// in copyPartSize ranges, aborting the upload if any part fails.
+func (c *S3Client) multipartCopy(ctx context.Context, copySource, dstKey string, size int64) error {
+	created, err := c.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(c.bucket),
+		Key:               aws.String(dstKey),
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	})
+	if err != nil {
+		return err
+	}
+	uploadID := created.UploadId
+
+	var completed []types.CompletedPart
+	for start, partNumber := int64(0), int32(1); start < size; start, partNumber = start+copyPartSize, partNumber+1 {
+		end := min(start+copyPartSize, size) - 1
+		out, err := c.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(c.bucket),
+			Key:             aws.String(dstKey),
+			UploadId:        uploadID,
+			PartNumber:      aws.Int32(partNumber),
+			CopySource:      aws.String(copySource),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		})
+		if err != nil {
+			c.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(c.bucket),
+				Key:      aws.String(dstKey),
+				UploadId: uploadID,
+			})
+			return err
+		}
+		completed = append(completed, types.CompletedPart{
+			ETag:           out.CopyPartResult.ETag,
+			PartNumber:     aws.Int32(partNumber),
+			ChecksumSHA256: out.CopyPartResult.ChecksumSHA256,
+		})
+	}
+
+	_, err = c.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(c.bucket),
+		Key:             aws.String(dstKey),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	return err
+}
+
 func (c *S3Client) DeletePrefix(ctx context.Context, clientID, prefix string) (int, error) {
 	fullPrefix := c.buildKey(clientID, prefix)
 
@@ -145,19 +312,7 @@ func (c *S3Client) DeletePrefix(ctx context.Context, clientID, prefix string) (i
 		}
 	}
 
-	if len(toDelete) == 0 {
-		return 0, nil
-	}
-
-	_, err := c.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
-		Bucket: aws.String(c.bucket),
-		Delete: &types.Delete{Objects: toDelete},
-	})
-	if err != nil {
-		return 0, err
-	}
-
-	return len(toDelete), nil
+	return c.deleteObjects(ctx, toDelete)
 }
 
 func (c *S3Client) List(ctx context.Context, clientID, prefix string) ([]ListEntry, error) {
@@ -186,3 +341,257 @@ func (c *S3Client) List(ctx context.Context, clientID, prefix string) ([]ListEnt
 
 	return entries, nil
 }
+
+// ListVersions relies on versioning being enabled on the bucket; without it
+// S3 reports a single version with the ID "null".
+func (c *S3Client) ListVersions(ctx context.Context, clientID, remotePath string) ([]ObjectVersion, error) {
+	key := c.buildKey(clientID, remotePath)
+
+	var versions []ObjectVersion
+	paginator := s3.NewListObjectVersionsPaginator(c.client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(c.bucket),
+		Prefix: aws.String(key),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range page.Versions {
+			if aws.ToString(v.Key) != key {
+				continue
+			}
+			versions = append(versions, ObjectVersion{
+				VersionID:    aws.ToString(v.VersionId),
+				Size:         aws.ToInt64(v.Size),
+				LastModified: aws.ToTime(v.LastModified),
+				IsLatest:     aws.ToBool(v.IsLatest),
+			})
+		}
+		for _, m := range page.DeleteMarkers {
+			if aws.ToString(m.Key) != key {
+				continue
+			}
+			versions = append(versions, ObjectVersion{
+				VersionID:    aws.ToString(m.VersionId),
+				LastModified: aws.ToTime(m.LastModified),
+				IsLatest:     aws.ToBool(m.IsLatest),
+				DeleteMarker: true,
+			})
+		}
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+	return versions, nil
+}
+
+func (c *S3Client) ListPrefixVersions(ctx context.Context, clientID, prefix string) ([]ObjectVersion, error) {
+	fullPrefix := c.buildKey(clientID, prefix)
+	clientRoot := c.buildKey(clientID, "") + "/"
+
+	var versions []ObjectVersion
+	paginator := s3.NewListObjectVersionsPaginator(c.client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(c.bucket),
+		Prefix: aws.String(fullPrefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range page.Versions {
+			versions = append(versions, ObjectVersion{
+				Path:         strings.TrimPrefix(aws.ToString(v.Key), clientRoot),
+				VersionID:    aws.ToString(v.VersionId),
+				Size:         aws.ToInt64(v.Size),
+				LastModified: aws.ToTime(v.LastModified),
+				IsLatest:     aws.ToBool(v.IsLatest),
+			})
+		}
+		for _, m := range page.DeleteMarkers {
+			versions = append(versions, ObjectVersion{
+				Path:         strings.TrimPrefix(aws.ToString(m.Key), clientRoot),
+				VersionID:    aws.ToString(m.VersionId),
+				LastModified: aws.ToTime(m.LastModified),
+				IsLatest:     aws.ToBool(m.IsLatest),
+				DeleteMarker: true,
+			})
+		}
+	}
+
+	sortPrefixVersions(versions)
+	return versions, nil
+}
+
+// sortPrefixVersions orders a multi-path listing by path, newest first
+// within a path.
+func sortPrefixVersions(versions []ObjectVersion) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Path != versions[j].Path {
+			return versions[i].Path < versions[j].Path
+		}
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+}
+
+func (c *S3Client) DownloadVersion(ctx context.Context, clientID, remotePath, versionID string) (io.ReadCloser, string, error) {
+	key := c.buildKey(clientID, remotePath)
+
+	result, err := c.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:    aws.String(c.bucket),
+		Key:       aws.String(key),
+		VersionId: aws.String(versionID),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		var apiErr smithy.APIError
+		if errors.As(err, &noSuchKey) || (errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchVersion") {
+			return nil, "", os.ErrNotExist
+		}
+		return nil, "", err
+	}
+
+	contentType := "application/octet-stream"
+	if result.ContentType != nil {
+		contentType = *result.ContentType
+	}
+
+	return result.Body, contentType, nil
+}
+
+// PruneVersions permanently deletes all but the newest keep versions,
+// counting delete markers.
+func (c *S3Client) PruneVersions(ctx context.Context, clientID, remotePath string, keep int) (int, error) {
+	versions, err := c.ListVersions(ctx, clientID, remotePath)
+	if err != nil || len(versions) <= keep {
+		return 0, err
+	}
+
+	key := c.buildKey(clientID, remotePath)
+	var toDelete []types.ObjectIdentifier
+	for _, v := range versions[keep:] {
+		toDelete = append(toDelete, types.ObjectIdentifier{
+			Key:       aws.String(key),
+			VersionId: aws.String(v.VersionID),
+		})
+	}
+	return c.deleteObjects(ctx, toDelete)
+}
+
+// deleteObjects deletes objects in batches of 1000, the most DeleteObjects
+// accepts, and returns how many S3 reports deleted. Keys S3 refuses to
+// delete are logged and reported as one error once every batch was tried.
+func (c *S3Client) deleteObjects(ctx context.Context, objects []types.ObjectIdentifier) (int, error) {
+	deleted, failed := 0, 0
+	for start := 0; start < len(objects); start += 1000 {
+		end := min(start+1000, len(objects))
+		out, err := c.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(c.bucket),
+			Delete: &types.Delete{Objects: objects[start:end]},
+		})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += len(out.Deleted)
+		for _, e := range out.Errors {
+			log.Printf("failed to delete %s (version %s): %s: %s",
+				aws.ToString(e.Key), aws.ToString(e.VersionId), aws.ToString(e.Code), aws.ToString(e.Message))
+		}
+		failed += len(out.Errors)
+	}
+
+	if failed > 0 {
+		return deleted, fmt.Errorf("%d of %d objects could not be deleted", failed, len(objects))
+	}
+	return deleted, nil
+}
+
+func (c *S3Client) CreateMultipartUpload(ctx context.Context, clientID, remotePath string) (string, error) {
+	key := c.buildKey(clientID, remotePath)
+
+	result, err := c.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(c.bucket),
+		Key:               aws.String(key),
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.ToString(result.UploadId), nil
+}
+
+func (c *S3Client) UploadPart(ctx context.Context, clientID, remotePath, uploadID string, partNumber int32, body io.Reader, size int64, checksum string) (string, error) {
+	key := c.buildKey(clientID, remotePath)
+
+	result, err := c.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:         aws.String(c.bucket),
+		Key:            aws.String(key),
+		UploadId:       aws.String(uploadID),
+		PartNumber:     aws.Int32(partNumber),
+		Body:           body,
+		ContentLength:  aws.Int64(size),
+		ChecksumSHA256: sha256Base64(checksum),
+	}, unsignedPayload)
+	if err != nil {
+		return "", mapChecksumError(mapNoSuchUpload(err))
+	}
+
+	return aws.ToString(result.ETag), nil
+}
+
+func (c *S3Client) CompleteMultipartUpload(ctx context.Context, clientID, remotePath, uploadID string, parts []CompletedPart) (string, int64, error) {
+	key := c.buildKey(clientID, remotePath)
+
+	completed := make([]types.CompletedPart, len(parts))
+	for i, p := range parts {
+		completed[i] = types.CompletedPart{
+			ETag:           aws.String(p.ETag),
+			PartNumber:     aws.Int32(p.PartNumber),
+			ChecksumSHA256: sha256Base64(p.SHA256),
+		}
+	}
+
+	_, err := c.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(c.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return "", 0, mapChecksumError(mapNoSuchUpload(err))
+	}
+
+	head, err := c.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	return key, aws.ToInt64(head.ContentLength), nil
+}
+
+func (c *S3Client) AbortMultipartUpload(ctx context.Context, clientID, remotePath, uploadID string) error {
+	key := c.buildKey(clientID, remotePath)
+
+	_, err := c.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(c.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return mapNoSuchUpload(err)
+}
+
+func mapNoSuchUpload(err error) error {
+	var noSuchUpload *types.NoSuchUpload
+	if errors.As(err, &noSuchUpload) {
+		return os.ErrNotExist
+	}
+	return err
+}
@@ -2,16 +2,28 @@ package server
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 type FakeStorage struct {
-	mu         sync.RWMutex
-	baseDir    string
-	pathPrefix string
+	mu          sync.RWMutex
+	baseDir     string
+	pathPrefix  string
+	lastVersion int64
 }
 
 func NewFakeStorage(baseDir, pathPrefix string) *FakeStorage {
@@ -25,22 +37,83 @@ func (f *FakeStorage) buildPath(clientID, remotePath string) string {
 	return filepath.Join(f.baseDir, f.pathPrefix, clientID, remotePath)
 }
 
-func (f *FakeStorage) Upload(ctx context.Context, clientID, remotePath string, body io.Reader, size int64) (string, error) {
-	f.mu.Lock()
-	defer f.mu.Unlock()
+// versionsDir holds every stored version of an object, named by a
+// nanosecond timestamp, plus empty "<timestamp>.deleted" delete markers,
+// mimicking a versioned bucket. It lives outside the
+// client's tree so List and DeletePrefix do not see it.
+func (f *FakeStorage) versionsDir(clientID, remotePath string) string {
+	return filepath.Join(f.baseDir, ".versions", f.pathPrefix, clientID, remotePath)
+}
 
+// publish moves a finished temp file into place and records it as the
+// newest version. The caller must hold f.mu.
+func (f *FakeStorage) publish(tmpPath, clientID, remotePath string) error {
+	fullPath := f.buildPath(clientID, remotePath)
+	if err := os.Rename(tmpPath, fullPath); err != nil {
+		return err
+	}
+
+	versionPath, err := f.newVersionPath(clientID, remotePath)
+	if err != nil {
+		return err
+	}
+	return os.Link(fullPath, versionPath)
+}
+
+// newVersionPath returns the path for the next version of an object. The
+// caller must hold f.mu.
+func (f *FakeStorage) newVersionPath(clientID, remotePath string) (string, error) {
+	dir := f.versionsDir(clientID, remotePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	version := time.Now().UnixNano()
+	if version <= f.lastVersion {
+		version = f.lastVersion + 1
+	}
+	f.lastVersion = version
+
+	return filepath.Join(dir, strconv.FormatInt(version, 10)), nil
+}
+
+// remove deletes the current object and leaves a delete marker, like
+// DeleteObject on a versioned bucket. The caller must hold f.mu.
+func (f *FakeStorage) remove(clientID, remotePath string) error {
+	markerPath, err := f.newVersionPath(clientID, remotePath)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(f.buildPath(clientID, remotePath)); err != nil {
+		return err
+	}
+	return os.WriteFile(markerPath+".deleted", nil, 0644)
+}
+
+func (f *FakeStorage) Upload(ctx context.Context, clientID, remotePath string, body io.Reader, size int64, checksum string) (string, error) {
 	fullPath := f.buildPath(clientID, remotePath)
 	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
 		return "", err
 	}
 
-	file, err := os.Create(fullPath)
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), ".upload-*")
 	if err != nil {
 		return "", err
 	}
-	defer file.Close()
+	defer os.Remove(tmp.Name())
 
-	if _, err := io.Copy(file, body); err != nil {
+	if err := copyVerified(tmp, body, checksum); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.publish(tmp.Name(), clientID, remotePath); err != nil {
 		return "", err
 	}
 
@@ -48,19 +121,32 @@ func (f *FakeStorage) Upload(ctx context.Context, clientID, remotePath string, b
 	return key, nil
 }
 
-func (f *FakeStorage) Exists(ctx context.Context, clientID, remotePath string) (bool, error) {
+// copyVerified copies body to dst and, like S3 with ChecksumSHA256 set,
+// fails if the data does not match checksum.
+func copyVerified(dst io.Writer, body io.Reader, checksum string) error {
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dst, h), body); err != nil {
+		return err
+	}
+	if checksum != "" && hex.EncodeToString(h.Sum(nil)) != checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+func (f *FakeStorage) Exists(ctx context.Context, clientID, remotePath string) (bool, int64, error) {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
 
 	fullPath := f.buildPath(clientID, remotePath)
-	_, err := os.Stat(fullPath)
+	info, err := os.Stat(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return false, nil
+			return false, 0, nil
 		}
-		return false, err
+		return false, 0, err
 	}
-	return true, nil
+	return true, info.Size(), nil
 }
 
 func (f *FakeStorage) Download(ctx context.Context, clientID, remotePath string) (io.ReadCloser, string, error) {
@@ -83,27 +169,99 @@ func (f *FakeStorage) GetFilePath(clientID, remotePath string) string {
 	return f.buildPath(clientID, remotePath)
 }
 
-func (f *FakeStorage) DeletePrefix(ctx context.Context, clientID, prefix string) (int, error) {
+func (f *FakeStorage) Delete(ctx context.Context, clientID, remotePath string) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	dir := f.buildPath(clientID, prefix)
-	entries, err := os.ReadDir(dir)
+	err := f.remove(clientID, remotePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (f *FakeStorage) Copy(ctx context.Context, clientID, srcPath, dstPath string, size int64) (string, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	src, err := os.Open(f.buildPath(clientID, srcPath))
 	if err != nil {
 		if os.IsNotExist(err) {
-			return 0, nil
+			return "", 0, os.ErrNotExist
 		}
+		return "", 0, err
+	}
+	defer src.Close()
+
+	if info, err := src.Stat(); err != nil {
+		return "", 0, err
+	} else if size > 0 && info.Size() != size {
+		return "", 0, ErrSizeMismatch
+	}
+
+	fullPath := f.buildPath(clientID, dstPath)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return "", 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), ".upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err = io.Copy(tmp, src)
+	if err != nil {
+		tmp.Close()
+		return "", 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+	if err := f.publish(tmp.Name(), clientID, dstPath); err != nil {
+		return "", 0, err
+	}
+
+	key := filepath.Join(f.pathPrefix, clientID, dstPath)
+	return key, size, nil
+}
+
+func (f *FakeStorage) DeletePrefix(ctx context.Context, clientID, prefix string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dir := f.buildPath(clientID, prefix)
+	clientRoot := f.buildPath(clientID, "")
+
+	var remotePaths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			relPath, _ := filepath.Rel(clientRoot, path)
+			remotePaths = append(remotePaths, relPath)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
 		return 0, err
 	}
 
-	count := 0
-	for _, e := range entries {
-		if !e.IsDir() {
-			os.Remove(filepath.Join(dir, e.Name()))
-			count++
+	// Like DeleteObjects, a failed key does not stop the others, and the
+	// count of deleted objects is returned along with the error.
+	deleted := 0
+	for _, remotePath := range remotePaths {
+		if err := f.remove(clientID, remotePath); err != nil {
+			log.Printf("failed to delete %s: %v", remotePath, err)
+			continue
 		}
+		deleted++
+	}
+	if deleted < len(remotePaths) {
+		return deleted, fmt.Errorf("%d of %d objects could not be deleted", len(remotePaths)-deleted, len(remotePaths))
 	}
-	return count, nil
+	return deleted, nil
 }
 
 func (f *FakeStorage) List(ctx context.Context, clientID, prefix string) ([]ListEntry, error) {
@@ -137,3 +295,269 @@ func (f *FakeStorage) List(ctx context.Context, clientID, prefix string) ([]List
 	}
 	return entries, nil
 }
+
+func (f *FakeStorage) ListVersions(ctx context.Context, clientID, remotePath string) ([]ObjectVersion, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.listVersions(clientID, remotePath)
+}
+
+func (f *FakeStorage) listVersions(clientID, remotePath string) ([]ObjectVersion, error) {
+	dir := f.versionsDir(clientID, remotePath)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var versions []ObjectVersion
+	for _, e := range entries {
+		name, marker := strings.CutSuffix(e.Name(), ".deleted")
+		nanos, err := strconv.ParseInt(name, 10, 64)
+		if err != nil || e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, ObjectVersion{
+			VersionID:    e.Name(),
+			Size:         info.Size(),
+			LastModified: time.Unix(0, nanos).UTC(),
+			DeleteMarker: marker,
+		})
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+	if len(versions) > 0 {
+		versions[0].IsLatest = true
+	}
+	return versions, nil
+}
+
+func (f *FakeStorage) ListPrefixVersions(ctx context.Context, clientID, prefix string) ([]ObjectVersion, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	root := f.versionsDir(clientID, "")
+	var versions []ObjectVersion
+	err := filepath.WalkDir(f.versionsDir(clientID, prefix), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		remotePath := filepath.ToSlash(rel)
+		pathVersions, err := f.listVersions(clientID, remotePath)
+		if err != nil {
+			return err
+		}
+		for _, v := range pathVersions {
+			v.Path = remotePath
+			versions = append(versions, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortPrefixVersions(versions)
+	return versions, nil
+}
+
+func (f *FakeStorage) DownloadVersion(ctx context.Context, clientID, remotePath, versionID string) (io.ReadCloser, string, error) {
+	if _, err := strconv.ParseInt(versionID, 10, 64); err != nil {
+		return nil, "", os.ErrNotExist
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	file, err := os.Open(filepath.Join(f.versionsDir(clientID, remotePath), versionID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, "", os.ErrNotExist
+		}
+		return nil, "", err
+	}
+
+	return file, "application/octet-stream", nil
+}
+
+func (f *FakeStorage) PruneVersions(ctx context.Context, clientID, remotePath string, keep int) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	versions, err := f.listVersions(clientID, remotePath)
+	if err != nil || len(versions) <= keep {
+		return 0, err
+	}
+
+	dir := f.versionsDir(clientID, remotePath)
+	for _, v := range versions[keep:] {
+		if err := os.Remove(filepath.Join(dir, v.VersionID)); err != nil {
+			return 0, err
+		}
+	}
+	return len(versions) - keep, nil
+}
+
+func (f *FakeStorage) multipartDir(uploadID string) (string, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return "", os.ErrNotExist
+	}
+	return filepath.Join(f.baseDir, ".multipart", uploadID), nil
+}
+
+// openMultipart returns the staging directory of an upload after checking
+// that it was started for the same client and path.
+func (f *FakeStorage) openMultipart(clientID, remotePath, uploadID string) (string, error) {
+	dir, err := f.multipartDir(uploadID)
+	if err != nil {
+		return "", err
+	}
+	target, err := os.ReadFile(filepath.Join(dir, "target"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", os.ErrNotExist
+		}
+		return "", err
+	}
+	if string(target) != f.buildPath(clientID, remotePath) {
+		return "", os.ErrNotExist
+	}
+	return dir, nil
+}
+
+func (f *FakeStorage) CreateMultipartUpload(ctx context.Context, clientID, remotePath string) (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(id[:])
+
+	dir, _ := f.multipartDir(uploadID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "target"), []byte(f.buildPath(clientID, remotePath)), 0644); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+func (f *FakeStorage) UploadPart(ctx context.Context, clientID, remotePath, uploadID string, partNumber int32, body io.Reader, size int64, checksum string) (string, error) {
+	dir, err := f.openMultipart(clientID, remotePath, uploadID)
+	if err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".part-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := md5.New()
+	if err := copyVerified(io.MultiWriter(tmp, h), body, checksum); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, fmt.Sprintf("part-%05d", partNumber))); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (f *FakeStorage) CompleteMultipartUpload(ctx context.Context, clientID, remotePath, uploadID string, parts []CompletedPart) (string, int64, error) {
+	dir, err := f.openMultipart(clientID, remotePath, uploadID)
+	if err != nil {
+		return "", 0, err
+	}
+
+	fullPath := f.buildPath(clientID, remotePath)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return "", 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), ".upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	var size int64
+	for i, p := range parts {
+		if i > 0 && p.PartNumber <= parts[i-1].PartNumber {
+			tmp.Close()
+			return "", 0, fmt.Errorf("parts must be in ascending order")
+		}
+		n, err := appendPart(tmp, filepath.Join(dir, fmt.Sprintf("part-%05d", p.PartNumber)), p)
+		if err != nil {
+			tmp.Close()
+			return "", 0, err
+		}
+		size += n
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.publish(tmp.Name(), clientID, remotePath); err != nil {
+		return "", 0, err
+	}
+	os.RemoveAll(dir)
+
+	key := filepath.Join(f.pathPrefix, clientID, remotePath)
+	return key, size, nil
+}
+
+func appendPart(dst io.Writer, partPath string, p CompletedPart) (int64, error) {
+	part, err := os.Open(partPath)
+	if err != nil {
+		return 0, fmt.Errorf("invalid part %s: %w", filepath.Base(partPath), err)
+	}
+	defer part.Close()
+
+	etag := md5.New()
+	digest := sha256.New()
+	n, err := io.Copy(io.MultiWriter(dst, etag, digest), part)
+	if err != nil {
+		return 0, err
+	}
+	if hex.EncodeToString(etag.Sum(nil)) != p.ETag {
+		return 0, fmt.Errorf("etag mismatch for %s", filepath.Base(partPath))
+	}
+	// Like S3, a part's digest in the complete request must match.
+	if p.SHA256 != "" && hex.EncodeToString(digest.Sum(nil)) != strings.ToLower(p.SHA256) {
+		return 0, ErrChecksumMismatch
+	}
+	return n, nil
+}
+
+func (f *FakeStorage) AbortMultipartUpload(ctx context.Context, clientID, remotePath, uploadID string) error {
+	dir, err := f.openMultipart(clientID, remotePath, uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
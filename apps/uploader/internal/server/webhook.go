@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers sent with every webhook delivery. The signature is
+// "sha256=" followed by the hex HMAC-SHA256 of the body, keyed with the
+// endpoint's secret, or alerts.secret for client alerts; it is only sent
+// when there is a secret.
+const (
+	EventHeader     = "X-S3up-Event"
+	DeliveryHeader  = "X-S3up-Delivery"
+	SignatureHeader = "X-S3up-Signature"
+)
+
+// OutboxEntry is one webhook delivery waiting in the webhook_outbox table.
+type OutboxEntry struct {
+	ID      int64
+	URL     string
+	Event   string
+	Payload []byte
+	// DedupKey makes enqueueing the same delivery twice a no-op.
+	DedupKey      string
+	Attempts      int
+	NextAttemptAt int64
+}
+
+const (
+	webhookMaxAttempts  = 10
+	webhookInitialDelay = 30 * time.Second
+	webhookMaxDelay     = time.Hour
+
+	// Delivered and given-up entries are kept this long for inspection,
+	// then pruned once every webhookPruneInterval.
+	webhookRetention     = 7 * 24 * time.Hour
+	webhookPruneInterval = time.Hour
+)
+
+// webhookRetryDelay doubles from webhookInitialDelay after each failed
+// attempt, capped at webhookMaxDelay.
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookInitialDelay
+	for i := 1; i < attempts && delay < webhookMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > webhookMaxDelay {
+		delay = webhookMaxDelay
+	}
+	return delay
+}
+
+// WebhookDispatcher delivers queued webhook_outbox entries. A delivery is
+// retried with exponential backoff until the endpoint answers 2xx or it has
+// failed webhookMaxAttempts times.
+type WebhookDispatcher struct {
+	db          *DB
+	client      *http.Client
+	interval    time.Duration
+	secrets     map[string]string
+	alertSecret string
+	lastPrune   time.Time
+}
+
+func NewWebhookDispatcher(db *DB) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		db:       db,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		interval: 5 * time.Second,
+	}
+}
+
+// SetEndpoints sets the secrets deliveries are signed with, looked up by
+// URL. Looking them up at delivery time lets a rotated secret apply to
+// deliveries that are already queued.
+func (d *WebhookDispatcher) SetEndpoints(endpoints []WebhookConfig) {
+	d.secrets = make(map[string]string)
+	for _, wh := range endpoints {
+		if wh.Secret != "" {
+			d.secrets[wh.URL] = wh.Secret
+		}
+	}
+}
+
+// SetAlertSecret sets the secret client alerts are signed with. Alerts are
+// sent unsigned without one, whatever their URL.
+func (d *WebhookDispatcher) SetAlertSecret(secret string) {
+	d.alertSecret = secret
+}
+
+// SignPayload returns the signature header value for payload.
+func SignPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *WebhookDispatcher) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.DeliverDue(); err != nil {
+			log.Printf("webhook delivery failed: %v", err)
+		}
+		if time.Since(d.lastPrune) >= webhookPruneInterval {
+			if _, err := d.Prune(); err != nil {
+				log.Printf("webhook outbox pruning failed: %v", err)
+			}
+			d.lastPrune = time.Now()
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue attempts every delivery whose next attempt time has passed and
+// returns how many succeeded.
+func (d *WebhookDispatcher) DeliverDue() (int, error) {
+	entries, err := d.db.DueWebhooks(time.Now().UTC().Unix(), webhookMaxAttempts)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, entry := range entries {
+		now := time.Now().UTC()
+		if err := d.deliver(entry); err != nil {
+			attempts := entry.Attempts + 1
+			if attempts >= webhookMaxAttempts {
+				log.Printf("giving up on %s webhook to %s after %d attempts: %v", entry.Event, entry.URL, attempts, err)
+			}
+			next := now.Add(webhookRetryDelay(attempts)).Unix()
+			if dbErr := d.db.WebhookFailed(entry.ID, attempts, next, err.Error()); dbErr != nil {
+				return delivered, dbErr
+			}
+			continue
+		}
+		if err := d.db.WebhookDelivered(entry.ID, now.Unix()); err != nil {
+			return delivered, err
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+// Prune deletes outbox entries that were delivered or given up on more
+// than webhookRetention ago and returns how many were deleted.
+func (d *WebhookDispatcher) Prune() (int64, error) {
+	before := time.Now().UTC().Add(-webhookRetention).Unix()
+	return d.db.PruneWebhooks(before, webhookMaxAttempts)
+}
+
+func (d *WebhookDispatcher) deliver(entry OutboxEntry) error {
+	req, err := http.NewRequest("POST", entry.URL, bytes.NewReader(entry.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, entry.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(entry.ID, 10))
+	secret := d.secrets[entry.URL]
+	if isAlertEvent(entry.Event) {
+		secret = d.alertSecret
+	}
+	if secret != "" {
+		req.Header.Set(SignatureHeader, SignPayload(secret, entry.Payload))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint answered %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"s3uploader/internal/server"
+)
+
+func TestE2E_StaleClientAlerts(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	serverDB, err := server.NewDB(filepath.Join(env.tmpDir, "server.db"))
+	if err != nil {
+		t.Fatalf("failed to create server db: %v", err)
+	}
+	defer serverDB.Close()
+
+	var mu sync.Mutex
+	var alerts []server.ClientAlert
+	var failNext atomic.Bool
+	failNext.Store(true)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failNext.Swap(false) {
+			http.Error(w, "simulated failure", http.StatusBadGateway)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get(server.SignatureHeader); got != server.SignPayload("alert-secret", body) {
+			t.Errorf("alert not signed with the alerts secret: %q", got)
+		}
+		var alert server.ClientAlert
+		json.Unmarshal(body, &alert)
+		mu.Lock()
+		alerts = append(alerts, alert)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	// The client was last seen two hours ago, before a server restart.
+	twoHoursAgo := time.Now().Add(-2 * time.Hour).Unix()
+	serverDB.SaveClientHealth(&server.ClientHealth{ID: "test-client", FirstSeen: twoHoursAgo, LastSeen: twoHoursAgo})
+
+	auth := server.NewAuthMiddleware([]server.ClientEntry{
+		{ID: "test-client", APIKey: "test-api-key"},
+		{ID: "never-seen", APIKey: "never-seen-key"},
+	})
+	handler := server.NewHandler(env.storage, serverDB)
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux, auth)
+	env.ts.Config.Handler = mux
+
+	cfg := server.AlertsConfig{Webhooks: []string{hook.URL}, Secret: "alert-secret", MaxSilenceMinutes: 60}
+	alerter := server.NewAlerter(serverDB, handler.Health(), auth, cfg)
+	dispatcher := server.NewWebhookDispatcher(serverDB)
+	// The alert URL doubling as a file event endpoint must not change how
+	// alerts are signed.
+	dispatcher.SetEndpoints([]server.WebhookConfig{{URL: hook.URL, Secret: "file-secret"}})
+	dispatcher.SetAlertSecret(cfg.Secret)
+
+	if changed, err := alerter.Check(); err != nil || changed != 1 {
+		t.Fatalf("expected test-client to go stale, got %d, %v", changed, err)
+	}
+	if changed, _ := alerter.Check(); changed != 0 {
+		t.Errorf("a stale client must only be alerted once, got %d", changed)
+	}
+	if changed, _ := server.NewAlerter(serverDB, handler.Health(), auth, cfg).Check(); changed != 0 {
+		t.Errorf("a restarted alerter must not alert again, got %d", changed)
+	}
+
+	if delivered, err := dispatcher.DeliverDue(); err != nil || delivered != 0 {
+		t.Fatalf("expected the first delivery to fail, got %d, %v", delivered, err)
+	}
+	due, err := serverDB.DueWebhooks(time.Now().Add(time.Minute).Unix(), 10)
+	if err != nil || len(due) != 1 || due[0].Attempts != 1 {
+		t.Fatalf("expected one delivery scheduled for retry, got %+v, %v", due, err)
+	}
+	// Make the retry due now instead of waiting for the backoff.
+	serverDB.WebhookFailed(due[0].ID, due[0].Attempts, 0, "")
+	if delivered, err := dispatcher.DeliverDue(); err != nil || delivered != 1 {
+		t.Fatalf("expected the retry to be delivered, got %d, %v", delivered, err)
+	}
+
+	if err := env.uploader.Heartbeat(); err != nil {
+		t.Fatalf("heartbeat failed: %v", err)
+	}
+	if changed, _ := alerter.Check(); changed != 1 {
+		t.Errorf("expected test-client to recover, got %d changes", changed)
+	}
+	if delivered, _ := dispatcher.DeliverDue(); delivered != 1 {
+		t.Errorf("expected the recovery alert to be delivered, got %d", delivered)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(alerts) != 2 {
+		t.Fatalf("expected 2 alerts, got %+v", alerts)
+	}
+	if a := alerts[0]; a.Event != server.AlertClientStale || a.ClientID != "test-client" || a.LastSeen != twoHoursAgo || a.SilenceSeconds < 7200 {
+		t.Errorf("unexpected stale alert: %+v", a)
+	}
+	if a := alerts[1]; a.Event != server.AlertClientRecovered || a.ClientID != "test-client" {
+		t.Errorf("unexpected recovery alert: %+v", a)
+	}
+}
+
+func TestE2E_WebhookOutboxPruned(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	serverDB, err := server.NewDB(filepath.Join(env.tmpDir, "server.db"))
+	if err != nil {
+		t.Fatalf("failed to create server db: %v", err)
+	}
+	defer serverDB.Close()
+
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(server.EventHeader) == "failing" {
+			http.Error(w, "simulated failure", http.StatusBadGateway)
+		}
+	}))
+	defer hook.Close()
+
+	var entries []server.OutboxEntry
+	for _, event := range []string{"first", "failing", "second"} {
+		entries = append(entries, server.OutboxEntry{URL: hook.URL, Event: event, Payload: []byte("{}"), DedupKey: event})
+	}
+	if err := serverDB.EnqueueWebhooks(entries); err != nil {
+		t.Fatalf("server db error: %v", err)
+	}
+	dispatcher := server.NewWebhookDispatcher(serverDB)
+	if delivered, err := dispatcher.DeliverDue(); err != nil || delivered != 2 {
+		t.Fatalf("expected 2 deliveries, got %d, %v", delivered, err)
+	}
+	due, err := serverDB.DueWebhooks(time.Now().Add(time.Hour).Unix(), 10)
+	if err != nil || len(due) != 1 {
+		t.Fatalf("expected the failing delivery to be pending, got %+v, %v", due, err)
+	}
+
+	// Fake a delivery that failed every attempt.
+	serverDB.EnqueueWebhooks([]server.OutboxEntry{{URL: hook.URL, Event: "failing", Payload: []byte("{}"), DedupKey: "exhausted"}})
+	due, _ = serverDB.DueWebhooks(time.Now().Add(time.Hour).Unix(), 10)
+	for _, e := range due {
+		if e.DedupKey == "exhausted" {
+			serverDB.WebhookFailed(e.ID, 10, 0, "simulated failure")
+		}
+	}
+
+	if pruned, err := dispatcher.Prune(); err != nil || pruned != 0 {
+		t.Errorf("recent entries must be kept, got %d pruned, %v", pruned, err)
+	}
+	pruned, err := serverDB.PruneWebhooks(time.Now().Add(time.Minute).Unix(), 10)
+	if err != nil || pruned != 3 {
+		t.Errorf("expected the 2 delivered and the given-up entry to be pruned, got %d, %v", pruned, err)
+	}
+	due, _ = serverDB.DueWebhooks(time.Now().Add(time.Hour).Unix(), 10)
+	if len(due) != 1 || due[0].DedupKey != "failing" {
+		t.Errorf("expected the pending delivery to be kept, got %+v", due)
+	}
+}
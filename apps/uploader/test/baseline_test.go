@@ -0,0 +1,113 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"s3uploader/internal/client"
+)
+
+func TestE2E_BaselineMode(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.Scan.Mode = client.ScanModeBaseline
+	preExisting := generateRandomFilesWithPrefix(t, env.watchDir, 3, "old_")
+
+	scanner := client.NewScanner(env.queue, env.db, env.cfg)
+	if err := scanner.Scan(); err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	if n := env.queue.Len(); n != 0 {
+		t.Fatalf("baseline scan should not queue anything, got %d", n)
+	}
+	for localPath := range preExisting {
+		rec, err := env.db.GetFile(localPath)
+		if err != nil {
+			t.Fatalf("db error: %v", err)
+		}
+		if rec == nil || rec.SkipReason == nil || *rec.SkipReason != client.SkipReasonBaseline {
+			t.Fatalf("expected %s to be recorded as %s, got %+v", localPath, client.SkipReasonBaseline, rec)
+		}
+	}
+
+	// A file created while the daemon was down is picked up on the next
+	// start instead of being baselined.
+	missed := generateRandomFilesWithPrefix(t, env.watchDir, 1, "missed_")
+	if err := client.NewScanner(env.queue, env.db, env.cfg).Scan(); err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	if n := env.queue.Len(); n != 1 {
+		t.Fatalf("expected only the missed file to be queued, got %d", n)
+	}
+
+	watcher, err := client.NewWatcher(env.queue, env.cfg)
+	if err != nil {
+		t.Fatalf("failed to create watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Start(); err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	var modifiedPath string
+	for p := range preExisting {
+		modifiedPath = p
+		break
+	}
+	content := []byte("modified after baseline")
+	time.Sleep(1100 * time.Millisecond)
+	if err := os.WriteFile(modifiedPath, content, 0644); err != nil {
+		t.Fatalf("failed to modify file: %v", err)
+	}
+	sum := sha256.Sum256(content)
+
+	expected := map[string]string{modifiedPath: hex.EncodeToString(sum[:])}
+	for p, h := range missed {
+		expected[p] = h
+	}
+	waitForUploads(t, env.db, expected, 30*time.Second)
+
+	for localPath := range preExisting {
+		if localPath == modifiedPath {
+			continue
+		}
+		rel, _ := filepath.Rel(env.watchDir, localPath)
+		if _, err := os.Stat(env.storage.GetFilePath("test-client", filepath.Join("uploads", rel))); err == nil {
+			t.Errorf("untouched baseline file %s should not be uploaded", localPath)
+		}
+	}
+}
+
+func TestE2E_BaselineSkipsFilesQueuedDuringStartup(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.Scan.Mode = client.ScanModeBaseline
+	generateRandomFilesWithPrefix(t, env.watchDir, 2, "old_")
+
+	// The watcher is already running and saw this file being written.
+	written := generateRandomFilesWithPrefix(t, env.watchDir, 1, "startup_")
+	for localPath := range written {
+		env.queue.Enqueue(localPath, filepath.Join("uploads", filepath.Base(localPath)))
+	}
+
+	if err := client.NewScanner(env.queue, env.db, env.cfg).Scan(); err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	waitForUploads(t, env.db, written, 30*time.Second)
+}
@@ -0,0 +1,110 @@
+package test
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestE2E_ChecksumRecorded(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	testFiles := generateRandomFiles(t, env.watchDir, 2)
+	for localPath := range testFiles {
+		env.queue.Enqueue(localPath, filepath.Join("uploads", filepath.Base(localPath)))
+	}
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	waitForUploads(t, env.db, testFiles, 30*time.Second)
+
+	for localPath, expectedHash := range testFiles {
+		rec, err := env.db.GetFile(localPath)
+		if err != nil {
+			t.Fatalf("db error: %v", err)
+		}
+		if rec.SHA256 == nil || *rec.SHA256 != expectedHash {
+			t.Errorf("expected sha256 %s recorded for %s, got %v", expectedHash, localPath, rec.SHA256)
+		}
+	}
+}
+
+func TestE2E_ChecksumMismatchRejected(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	content := []byte("the bytes that were actually sent")
+	wrongHash := strings.Repeat("ab", 32)
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("path", "uploads/corrupt.txt")
+	w.WriteField("size", strconv.Itoa(len(content)))
+	w.WriteField("sha256", wrongHash)
+	part, _ := w.CreateFormFile("file", "corrupt.txt")
+	part.Write(content)
+	w.Close()
+
+	req, _ := http.NewRequest("POST", env.ts.URL+"/upload", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer test-api-key")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422, got %d", resp.StatusCode)
+	}
+
+	storagePath := env.storage.GetFilePath("test-client", "uploads/corrupt.txt")
+	if _, err := os.Stat(storagePath); err == nil {
+		t.Errorf("object with mismatched checksum should not have been stored")
+	}
+}
+
+func TestE2E_SizeMismatchNotStored(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	content := []byte("the bytes that were actually sent")
+	for _, size := range []int{len(content) - 5, len(content) + 5} {
+		remotePath := "uploads/size-" + strconv.Itoa(size) + ".txt"
+
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		w.WriteField("path", remotePath)
+		w.WriteField("size", strconv.Itoa(size))
+		part, _ := w.CreateFormFile("file", "wrong-size.txt")
+		part.Write(content)
+		w.Close()
+
+		req, _ := http.NewRequest("POST", env.ts.URL+"/upload", &body)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer test-api-key")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode < 400 {
+			t.Errorf("declared size %d: expected an error, got %d", size, resp.StatusCode)
+		}
+		if fileExists(env.storage.GetFilePath("test-client", remotePath)) {
+			t.Errorf("declared size %d: object should not have been stored", size)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"s3uploader/internal/client"
+	"s3uploader/internal/server"
+)
+
+func TestE2E_AutoCleanup(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.Watches[0].MirrorDeletes = true
+	env.cfg.Watches[0].DeleteGraceSeconds = 1
+
+	watcher, err := client.NewWatcher(env.queue, env.cfg)
+	if err != nil {
+		t.Fatalf("failed to create watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Start(); err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	sweeper := client.NewTombstoneSweeper(env.db, env.uploader, env.cfg)
+	go sweeper.Run(stopProcessor)
+
+	testFiles := generateRandomFiles(t, env.watchDir, 3)
+	waitForUploads(t, env.db, testFiles, 30*time.Second)
+
+	missingPath := filepath.Join(env.watchDir, "file_2.bin")
+	if err := os.Remove(env.storage.GetFilePath("test-client", "uploads/file_2.bin")); err != nil {
+		t.Fatalf("failed to remove stored object: %v", err)
+	}
+
+	cleaner := client.NewCleaner(env.db, env.uploader, env.cfg)
+	entries, err := cleaner.RunOnce(true)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	actions := make(map[string]string)
+	for _, e := range entries {
+		actions[e.LocalPath] = e.Action
+	}
+	for localPath := range testFiles {
+		expected := client.CleanupWouldDelete
+		if localPath == missingPath {
+			expected = client.CleanupKept
+		}
+		if actions[localPath] != expected {
+			t.Errorf("dry run: expected %s for %s, got %q", expected, localPath, actions[localPath])
+		}
+		if !fileExists(localPath) {
+			t.Errorf("dry run must not delete %s", localPath)
+		}
+	}
+
+	if _, err := cleaner.RunOnce(false); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+
+	// Give the watcher and the tombstone sweeper a chance to react.
+	time.Sleep(3 * time.Second)
+
+	for localPath := range testFiles {
+		if localPath == missingPath {
+			if !fileExists(localPath) {
+				t.Errorf("file missing on the server must be kept locally")
+			}
+			continue
+		}
+		if fileExists(localPath) {
+			t.Errorf("%s should have been deleted locally", localPath)
+		}
+
+		rec, err := env.db.GetFile(localPath)
+		if err != nil {
+			t.Fatalf("db error: %v", err)
+		}
+		if rec == nil || !rec.LocallyDeleted {
+			t.Errorf("%s should be marked locally_deleted, got %+v", localPath, rec)
+		}
+
+		rel, _ := filepath.Rel(env.watchDir, localPath)
+		if !fileExists(env.storage.GetFilePath("test-client", filepath.Join("uploads", rel))) {
+			t.Errorf("cleanup must not remove %s from the server", rel)
+		}
+	}
+}
+
+func TestE2E_CleanupKeepsFilesWhoseServerCopyDiffers(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	serverDB, err := server.NewDB(filepath.Join(env.tmpDir, "server.db"))
+	if err != nil {
+		t.Fatalf("failed to create server db: %v", err)
+	}
+	defer serverDB.Close()
+	mux := http.NewServeMux()
+	server.NewHandler(env.storage, serverDB).RegisterRoutes(mux, server.NewAuthMiddleware([]server.ClientEntry{
+		{ID: "test-client", APIKey: "test-api-key"},
+	}))
+	env.ts.Config.Handler = mux
+
+	testFiles := generateRandomFiles(t, env.watchDir, 3)
+	for localPath := range testFiles {
+		env.queue.Enqueue(localPath, "uploads/"+filepath.Base(localPath))
+	}
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+	waitForUploads(t, env.db, testFiles, 30*time.Second)
+
+	// file_0 was overwritten on the server with different content, and
+	// file_1 was recorded with a different checksum at the same size.
+	truncated := filepath.Join(env.watchDir, "file_0.bin")
+	if err := os.WriteFile(env.storage.GetFilePath("test-client", "uploads/file_0.bin"), []byte("short"), 0644); err != nil {
+		t.Fatalf("failed to overwrite stored object: %v", err)
+	}
+	replaced := filepath.Join(env.watchDir, "file_1.bin")
+	info, err := os.Stat(replaced)
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+	if err := serverDB.InsertUpload("test-client", "uploads/file_1.bin", info.Size(), strings.Repeat("0", 64)); err != nil {
+		t.Fatalf("db error: %v", err)
+	}
+
+	entries, err := client.NewCleaner(env.db, env.uploader, env.cfg).RunOnce(true)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	actions := make(map[string]string)
+	for _, e := range entries {
+		actions[e.LocalPath] = e.Action
+	}
+	for localPath := range testFiles {
+		expected := client.CleanupWouldDelete
+		if localPath == truncated || localPath == replaced {
+			expected = client.CleanupKept
+		}
+		if actions[localPath] != expected {
+			t.Errorf("expected %s for %s, got %q", expected, localPath, actions[localPath])
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"s3uploader/internal/client"
+	"s3uploader/internal/server"
+)
+
+func getClients(t *testing.T, url, apiKey string) (int, []server.ClientHealth) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url+"/clients", nil)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Clients []server.ClientHealth `json:"clients"`
+	}
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+	return resp.StatusCode, result.Clients
+}
+
+func TestE2E_ClientHealth(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	serverDB, err := server.NewDB(filepath.Join(env.tmpDir, "server.db"))
+	if err != nil {
+		t.Fatalf("failed to create server db: %v", err)
+	}
+	defer serverDB.Close()
+
+	auth := server.NewAuthMiddleware([]server.ClientEntry{
+		{ID: "test-client", APIKey: "test-api-key"},
+		{ID: "other-client", APIKey: "other-api-key"},
+	})
+	admin := server.NewAdminAuth([]string{"admin-key"})
+	serve := func() *server.Handler {
+		handler := server.NewHandler(env.storage, serverDB)
+		mux := http.NewServeMux()
+		handler.RegisterRoutes(mux, auth)
+		handler.RegisterAdminRoutes(mux, admin, auth)
+		env.ts.Config.Handler = mux
+		return handler
+	}
+	handler := serve()
+
+	if code, _ := getClients(t, env.ts.URL, "test-api-key"); code != http.StatusUnauthorized {
+		t.Errorf("a client key must not reach /clients, got %d", code)
+	}
+
+	_, clients := getClients(t, env.ts.URL, "admin-key")
+	if len(clients) != 2 || clients[0].Status != server.ClientNeverSeen || clients[1].Status != server.ClientNeverSeen {
+		t.Fatalf("expected two never-seen clients, got %+v", clients)
+	}
+
+	if err := env.uploader.Heartbeat(); err != nil {
+		t.Fatalf("heartbeat failed: %v", err)
+	}
+	hostname, _ := os.Hostname()
+	_, clients = getClients(t, env.ts.URL, "admin-key")
+	c := clients[1]
+	if c.ID != "test-client" || c.Status != server.ClientHealthy || c.Hostname != hostname || c.Version != client.Version || c.LastSeen == 0 {
+		t.Errorf("expected test-client to be healthy with host and version, got %+v", c)
+	}
+	if clients[0].Status != server.ClientNeverSeen {
+		t.Errorf("other-client was never seen, got %+v", clients[0])
+	}
+
+	env.db.AddDailyStats(client.DailyStats{Day: "2026-01-01", FilesUploaded: 1})
+	if _, err := client.NewReporter(env.queue, env.db, env.uploader).SendPending(); err != nil {
+		t.Fatalf("report failed: %v", err)
+	}
+
+	// The registry survives a server restart.
+	handler = serve()
+	_, clients = getClients(t, env.ts.URL, "admin-key")
+	if c := clients[1]; c.Hostname != hostname || c.LastReport == 0 {
+		t.Errorf("expected the restarted server to remember test-client, got %+v", c)
+	}
+
+	handler.Health().SetThresholds(time.Second, 0)
+	time.Sleep(2100 * time.Millisecond)
+	_, clients = getClients(t, env.ts.URL, "admin-key")
+	if clients[1].Status != server.ClientStale {
+		t.Errorf("expected test-client to be stale, got %+v", clients[1])
+	}
+}
@@ -0,0 +1,44 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"s3uploader/internal/client"
+)
+
+func TestE2E_ConcurrentUploads(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.Upload.Concurrent = 4
+	env.processor = client.NewProcessor(env.queue, env.db, env.uploader, env.cfg)
+
+	testFiles := generateRandomFiles(t, env.watchDir, 8)
+
+	env.cfg.Scan.UploadExisting = true
+	scanner := client.NewScanner(env.queue, env.db, env.cfg)
+	if err := scanner.Scan(); err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	// Sequentially each file spends at least debounce_seconds (1s) in the
+	// stability check, so 8 files would take over 8 seconds.
+	waitForUploads(t, env.db, testFiles, 6*time.Second)
+
+	for localPath, expectedHash := range testFiles {
+		relPath, _ := filepath.Rel(env.watchDir, localPath)
+		remotePath := filepath.Join("uploads", relPath)
+		storagePath := env.storage.GetFilePath("test-client", remotePath)
+
+		actualHash := hashFile(t, storagePath)
+		if actualHash != expectedHash {
+			t.Errorf("hash mismatch for %s: expected %s, got %s", localPath, expectedHash, actualHash)
+		}
+	}
+}
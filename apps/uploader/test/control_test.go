@@ -0,0 +1,159 @@
+package test
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"s3uploader/internal/client"
+)
+
+func TestE2E_ControlSocket(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.Scan.Mode = client.ScanModeUpload
+
+	var uploads atomic.Int32
+	inner := env.ts.Config.Handler
+	env.ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/upload" {
+			uploads.Add(1)
+		}
+		inner.ServeHTTP(w, r)
+	})
+
+	socketPath := filepath.Join(env.tmpDir, "s3up.sock")
+	control := client.NewControlServer(env.queue, env.db, env.processor, env.cfg)
+	if err := control.Listen(socketPath); err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer control.Close()
+
+	if info, err := os.Stat(socketPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected the socket to be 0600, got %v, %v", info, err)
+	}
+	entries, _ := os.ReadDir(env.tmpDir)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".s3up-control-") {
+			t.Errorf("the private directory %s should be removed", e.Name())
+		}
+	}
+
+	if err := client.NewControlServer(env.queue, env.db, env.processor, env.cfg).Listen(socketPath); err == nil {
+		t.Errorf("a second daemon must not take over a live socket")
+	}
+
+	cc := client.NewControlClient(socketPath)
+	if err := cc.Pause(); err != nil {
+		t.Fatalf("pause failed: %v", err)
+	}
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	testFiles := generateRandomFiles(t, env.watchDir, 3)
+	for localPath := range testFiles {
+		env.queue.EnqueueWithAttempts(localPath, filepath.Join("uploads", filepath.Base(localPath)), 1)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	snapshot, err := cc.Queue()
+	if err != nil {
+		t.Fatalf("queue failed: %v", err)
+	}
+	if len(snapshot.Entries) != 3 || snapshot.Entries[0].AttemptCount != 1 {
+		t.Errorf("expected 3 queued entries with 1 attempt while paused, got %+v", snapshot)
+	}
+
+	status, err := cc.Status(10)
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if !status.DaemonRunning || !status.Paused || status.Queued != 3 {
+		t.Errorf("expected a running, paused daemon with 3 queued files, got %+v", status)
+	}
+
+	if err := cc.Resume(); err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	waitForUploads(t, env.db, testFiles, 30*time.Second)
+
+	// An unchanged file is only uploaded again when forced.
+	before := uploads.Load()
+	var forced string
+	for localPath := range testFiles {
+		forced = localPath
+		break
+	}
+	forcedRec, err := env.db.GetFile(forced)
+	if err != nil || forcedRec == nil {
+		t.Fatalf("expected a files row for %s, got %+v, %v", forced, forcedRec, err)
+	}
+	// Make the new upload time distinguishable from the first one.
+	time.Sleep(1100 * time.Millisecond)
+	queued, err := cc.Reupload(forced)
+	if err != nil || queued != 1 {
+		t.Fatalf("expected reupload to queue 1 file, got %d, %v", queued, err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for uploads.Load() == before && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if uploads.Load() != before+1 {
+		t.Errorf("expected exactly one forced upload, got %d", uploads.Load()-before)
+	}
+	// The files row is updated in place rather than dropped and recreated.
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		rec, err := env.db.GetFile(forced)
+		if err != nil {
+			t.Fatalf("db error: %v", err)
+		}
+		if rec != nil && rec.ID == forcedRec.ID && *rec.UploadedAt > *forcedRec.UploadedAt {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("forced upload should update the existing files row, got %+v, was %+v", rec, forcedRec)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if _, err := cc.Reupload(filepath.Join(env.tmpDir, "storage")); err == nil {
+		t.Errorf("reupload outside a watch should fail")
+	}
+
+	missed := filepath.Join(env.watchDir, "missed.txt")
+	if err := os.WriteFile(missed, []byte("missed by the watcher"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if queued, err := cc.Rescan(); err != nil || queued != 1 {
+		t.Errorf("expected rescan to queue 1 file, got %d, %v", queued, err)
+	}
+
+	env.db.SaveFailedUpload(&client.FailedUpload{
+		LocalPath:    filepath.Join(env.watchDir, "broken.bin"),
+		RemotePath:   "uploads/broken.bin",
+		Error:        "simulated failure",
+		AttemptCount: 2,
+		NextRetryAt:  time.Now().Add(time.Hour).Unix(),
+	})
+	failed, err := cc.Failed()
+	if err != nil || len(failed) != 1 || failed[0].AttemptCount != 2 {
+		t.Errorf("expected one failed file, got %+v, %v", failed, err)
+	}
+
+	control.Close()
+	if _, err := cc.Status(10); !errors.Is(err, client.ErrDaemonNotRunning) {
+		t.Errorf("expected ErrDaemonNotRunning after close, got %v", err)
+	}
+	if fileExists(socketPath) {
+		t.Errorf("close should remove the socket")
+	}
+}
@@ -0,0 +1,122 @@
+package test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"s3uploader/internal/server"
+)
+
+type receivedEvent struct {
+	event     server.FileEvent
+	header    http.Header
+	signature string
+}
+
+func TestE2E_FileEventWebhooks(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	serverDB, err := server.NewDB(filepath.Join(env.tmpDir, "server.db"))
+	if err != nil {
+		t.Fatalf("failed to create server db: %v", err)
+	}
+	defer serverDB.Close()
+
+	var mu sync.Mutex
+	received := make(map[string][]receivedEvent)
+	receiver := func(name, secret string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			var e server.FileEvent
+			json.Unmarshal(body, &e)
+			mu.Lock()
+			received[name] = append(received[name], receivedEvent{e, r.Header, server.SignPayload(secret, body)})
+			mu.Unlock()
+		}))
+	}
+	indexer := receiver("indexer", "indexer-secret")
+	defer indexer.Close()
+	auditor := receiver("auditor", "")
+	defer auditor.Close()
+
+	endpoints := []server.WebhookConfig{
+		{URL: indexer.URL, Secret: "indexer-secret"},
+		{URL: auditor.URL, Events: []string{server.EventPrefixDeleted}},
+	}
+	auth := server.NewAuthMiddleware([]server.ClientEntry{{ID: "test-client", APIKey: "test-api-key"}})
+	handler := server.NewHandler(env.storage, serverDB)
+	handler.SetWebhooks(endpoints)
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux, auth)
+	env.ts.Config.Handler = mux
+
+	localPath := filepath.Join(env.watchDir, "a.txt")
+	if err := os.WriteFile(localPath, []byte("hello"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if _, err := env.uploader.Upload(localPath, "docs/a.txt"); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if _, err := env.uploader.Download("docs/a.txt", "", io.Discard); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if _, err := env.uploader.DeletePrefix("docs"); err != nil {
+		t.Fatalf("delete prefix failed: %v", err)
+	}
+
+	dispatcher := server.NewWebhookDispatcher(serverDB)
+	dispatcher.SetEndpoints(endpoints)
+	if delivered, err := dispatcher.DeliverDue(); err != nil || delivered != 4 {
+		t.Fatalf("expected 4 deliveries, got %d, %v", delivered, err)
+	}
+	if delivered, _ := dispatcher.DeliverDue(); delivered != 0 {
+		t.Errorf("deliveries must not repeat, got %d", delivered)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	events := received["indexer"]
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events for the indexer, got %d", len(events))
+	}
+	wantEvents := []string{server.EventFileUploaded, server.EventFileDownloaded, server.EventPrefixDeleted}
+	for i, r := range events {
+		if r.event.Event != wantEvents[i] || r.header.Get(server.EventHeader) != wantEvents[i] {
+			t.Errorf("event %d: expected %s, got %+v", i, wantEvents[i], r.event)
+		}
+		if r.event.ClientID != "test-client" || r.event.ID == "" {
+			t.Errorf("event %d: unexpected payload %+v", i, r.event)
+		}
+		if r.header.Get(server.SignatureHeader) != r.signature {
+			t.Errorf("event %d: bad signature %q", i, r.header.Get(server.SignatureHeader))
+		}
+	}
+	if e := events[0].event; e.Path != "docs/a.txt" || e.Size != 5 || e.SHA256 == "" {
+		t.Errorf("unexpected upload event: %+v", e)
+	}
+	if e := events[1].event; e.Path != "docs/a.txt" || e.Size != 5 {
+		t.Errorf("unexpected download event: %+v", e)
+	}
+	if e := events[2].event; e.Prefix != "docs" || e.Deleted != 1 {
+		t.Errorf("unexpected delete-prefix event: %+v", e)
+	}
+
+	events = received["auditor"]
+	if len(events) != 1 || events[0].event.Event != server.EventPrefixDeleted {
+		t.Fatalf("the auditor should only get prefix.deleted, got %+v", events)
+	}
+	if events[0].header.Get(server.SignatureHeader) != "" {
+		t.Errorf("deliveries to an endpoint without a secret must not be signed")
+	}
+	if events[0].event.ID != received["indexer"][2].event.ID {
+		t.Errorf("both endpoints should get the same event ID")
+	}
+}
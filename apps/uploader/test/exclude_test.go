@@ -28,7 +28,7 @@ func TestE2E_ExcludePatterns(t *testing.T) {
 	normalFiles := generateRandomFiles(t, env.watchDir, 3)
 
 	env.cfg.Scan.UploadExisting = true
-	scanner := client.NewScanner(env.queue, env.cfg)
+	scanner := client.NewScanner(env.queue, env.db, env.cfg)
 	if err := scanner.Scan(); err != nil {
 		t.Fatalf("failed to scan: %v", err)
 	}
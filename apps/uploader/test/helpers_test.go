@@ -134,7 +134,7 @@ func newTestEnvWithExcludes(t *testing.T, patterns []string) *testEnv {
 func buildTestEnv(t *testing.T, tmpDir, watchDir, storageDir, dbPath string, storage *server.FakeStorage, ts *httptest.Server, db *client.DB, queue *client.Queue, cfg *client.Config) *testEnv {
 	t.Helper()
 
-	uploader := client.NewUploader(cfg)
+	uploader := client.NewUploader(cfg, db)
 	processor := client.NewProcessor(queue, db, uploader, cfg)
 
 	return &testEnv{
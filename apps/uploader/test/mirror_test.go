@@ -0,0 +1,299 @@
+package test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"s3uploader/internal/client"
+	"s3uploader/internal/server"
+)
+
+func TestE2E_MirrorDeletesAndRenames(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.Watches[0].MirrorDeletes = true
+	env.cfg.Watches[0].DeleteGraceSeconds = 2
+
+	var uploads atomic.Int32
+	inner := env.ts.Config.Handler
+	env.ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/upload" {
+			uploads.Add(1)
+		}
+		inner.ServeHTTP(w, r)
+	})
+
+	watcher, err := client.NewWatcher(env.queue, env.cfg)
+	if err != nil {
+		t.Fatalf("failed to create watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Start(); err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	sweeper := client.NewTombstoneSweeper(env.db, env.uploader, env.cfg)
+	go sweeper.Run(stopProcessor)
+
+	testFiles := generateRandomFiles(t, env.watchDir, 2)
+	waitForUploads(t, env.db, testFiles, 30*time.Second)
+	uploadedBefore := uploads.Load()
+
+	deletedPath := filepath.Join(env.watchDir, "file_0.bin")
+	renamedPath := filepath.Join(env.watchDir, "file_1.bin")
+	newPath := filepath.Join(env.watchDir, "renamed.bin")
+	expectedHash := testFiles[renamedPath]
+
+	if err := os.Remove(deletedPath); err != nil {
+		t.Fatalf("failed to remove file: %v", err)
+	}
+	if err := os.Rename(renamedPath, newPath); err != nil {
+		t.Fatalf("failed to rename file: %v", err)
+	}
+
+	deletedStorage := env.storage.GetFilePath("test-client", "uploads/file_0.bin")
+	renamedStorage := env.storage.GetFilePath("test-client", "uploads/file_1.bin")
+	newStorage := env.storage.GetFilePath("test-client", "uploads/renamed.bin")
+
+	deadline := time.Now().Add(20 * time.Second)
+	for time.Now().Before(deadline) {
+		if !fileExists(deletedStorage) && !fileExists(renamedStorage) && fileExists(newStorage) {
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	if fileExists(deletedStorage) {
+		t.Errorf("deleted file should be removed from storage")
+	}
+	if fileExists(renamedStorage) {
+		t.Errorf("renamed file should be removed from its old path in storage")
+	}
+	if !fileExists(newStorage) {
+		t.Fatalf("renamed file should exist at its new path in storage")
+	}
+	if actual := hashFile(t, newStorage); actual != expectedHash {
+		t.Errorf("hash mismatch for renamed file: expected %s, got %s", expectedHash, actual)
+	}
+	if n := uploads.Load() - uploadedBefore; n != 0 {
+		t.Errorf("rename should use a server-side copy, got %d uploads", n)
+	}
+
+	for _, localPath := range []string{deletedPath, renamedPath} {
+		rec, err := env.db.GetFile(localPath)
+		if err != nil {
+			t.Fatalf("db error: %v", err)
+		}
+		if rec != nil {
+			t.Errorf("files row for %s should be removed", localPath)
+		}
+	}
+}
+
+func TestE2E_RenameCopyStaysInWatch(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	otherDir := filepath.Join(env.tmpDir, "other")
+	if err := os.MkdirAll(otherDir, 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	env.cfg.Watches[0].MirrorDeletes = true
+	env.cfg.Watches = append(env.cfg.Watches, client.WatchConfig{LocalPath: otherDir, RemotePrefix: "other/", MirrorDeletes: true})
+
+	var uploads, copies atomic.Int32
+	inner := env.ts.Config.Handler
+	env.ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/upload":
+			uploads.Add(1)
+		case "/copy":
+			copies.Add(1)
+		}
+		inner.ServeHTTP(w, r)
+	})
+
+	// The same content was uploaded from the other watch and then deleted
+	// there, leaving a tombstone with matching size, mtime and sha256.
+	content := []byte("identical content in two watches")
+	oldPath := filepath.Join(otherDir, "a.bin")
+	newPath := filepath.Join(env.watchDir, "a.bin")
+	for _, p := range []string{oldPath, newPath} {
+		if err := os.WriteFile(p, content, 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, p := range []string{oldPath, newPath} {
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatalf("failed to set mtime: %v", err)
+		}
+	}
+	resp, err := env.uploader.Upload(oldPath, "other/a.bin")
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	env.db.SaveTombstone(&client.Tombstone{
+		LocalPath:   oldPath,
+		RemotePath:  "other/a.bin",
+		FileSize:    int64(len(content)),
+		Mtime:       mtime.UTC().Unix(),
+		SHA256:      &resp.SHA256,
+		DeletedAt:   time.Now().Unix(),
+		DeleteAfter: time.Now().Add(time.Hour).Unix(),
+	})
+	uploads.Store(0)
+
+	env.queue.Enqueue(newPath, "uploads/a.bin")
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	waitForUploads(t, env.db, map[string]string{newPath: hashFile(t, newPath)}, 20*time.Second)
+	if copies.Load() != 0 || uploads.Load() != 1 {
+		t.Errorf("a tombstone in another watch must not be used as a rename source, got %d copies and %d uploads", copies.Load(), uploads.Load())
+	}
+}
+
+func TestE2E_CopyChecksSourceSize(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	localPath := filepath.Join(env.watchDir, "a.txt")
+	if err := os.WriteFile(localPath, []byte("copy me"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if _, err := env.uploader.Upload(localPath, "uploads/a.txt"); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+
+	if _, err := env.uploader.Copy("uploads/a.txt", "uploads/b.txt", 999, ""); err == nil {
+		t.Errorf("copy with the wrong size should fail")
+	}
+	if fileExists(env.storage.GetFilePath("test-client", "uploads/b.txt")) {
+		t.Errorf("a copy with the wrong size should not be stored")
+	}
+
+	resp, err := env.uploader.Copy("uploads/a.txt", "uploads/c.txt", 0, "")
+	if err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	if resp.Size != int64(len("copy me")) {
+		t.Errorf("copy should report the stored size, got %d", resp.Size)
+	}
+}
+
+func TestE2E_CopyRecordsSourceChecksum(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	serverDB, err := server.NewDB(filepath.Join(env.tmpDir, "server.db"))
+	if err != nil {
+		t.Fatalf("failed to create server db: %v", err)
+	}
+	defer serverDB.Close()
+	mux := http.NewServeMux()
+	server.NewHandler(env.storage, serverDB).RegisterRoutes(mux, server.NewAuthMiddleware([]server.ClientEntry{
+		{ID: "test-client", APIKey: "test-api-key"},
+	}))
+	env.ts.Config.Handler = mux
+
+	localPath := filepath.Join(env.watchDir, "a.txt")
+	if err := os.WriteFile(localPath, []byte("copy me"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	uploaded, err := env.uploader.Upload(localPath, "uploads/a.txt")
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	size := int64(len("copy me"))
+
+	if _, err := env.uploader.Copy("uploads/a.txt", "uploads/b.txt", size, strings.Repeat("0", 64)); err == nil {
+		t.Errorf("copy with a sha256 that differs from the source should fail")
+	}
+	if fileExists(env.storage.GetFilePath("test-client", "uploads/b.txt")) {
+		t.Errorf("a copy with the wrong sha256 should not be stored")
+	}
+
+	for _, to := range []string{"uploads/c.txt", "uploads/d.txt"} {
+		checksum := ""
+		if to == "uploads/c.txt" {
+			checksum = uploaded.SHA256
+		}
+		if _, err := env.uploader.Copy("uploads/a.txt", to, size, checksum); err != nil {
+			t.Fatalf("copy to %s failed: %v", to, err)
+		}
+		stat, err := env.uploader.Stat(to)
+		if err != nil {
+			t.Fatalf("stat failed: %v", err)
+		}
+		if stat.SHA256 != uploaded.SHA256 {
+			t.Errorf("%s should be recorded with the source's checksum, got %q", to, stat.SHA256)
+		}
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestE2E_NonASCIIDirectory(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.Watches[0].MirrorDeletes = true
+
+	dir := filepath.Join(env.watchDir, "café")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	testFiles := generateRandomFiles(t, dir, 2)
+	for localPath := range testFiles {
+		env.queue.Enqueue(localPath, filepath.Join("uploads", "café", filepath.Base(localPath)))
+	}
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+	waitForUploads(t, env.db, testFiles, 30*time.Second)
+
+	recs, err := env.db.ListFilesUnder(dir)
+	if err != nil {
+		t.Fatalf("db error: %v", err)
+	}
+	if len(recs) != 2 {
+		t.Errorf("expected 2 files under %s, got %d", dir, len(recs))
+	}
+	if queued, err := client.NewReconciler(env.queue, env.db, env.cfg).Reconcile(); err != nil || queued != 0 {
+		t.Errorf("uploaded files should not be queued again, got %d, %v", queued, err)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("failed to remove dir: %v", err)
+	}
+	env.queue.Enqueue(dir, filepath.Join("uploads", "café"))
+
+	deadline := time.Now().Add(10 * time.Second)
+	var due []client.Tombstone
+	for time.Now().Before(deadline) && len(due) < 2 {
+		if due, err = env.db.DueTombstones(time.Now().Add(time.Hour).Unix()); err != nil {
+			t.Fatalf("db error: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if len(due) != 2 {
+		t.Errorf("expected a tombstone for each file in the deleted directory, got %+v", due)
+	}
+}
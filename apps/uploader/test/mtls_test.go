@@ -0,0 +1,157 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"s3uploader/internal/client"
+	"s3uploader/internal/server"
+)
+
+type testCA struct {
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T, dir string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create ca: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	ca := &testCA{dir: dir, cert: cert, key: key, file: filepath.Join(dir, "ca.pem")}
+	writeCertPair(t, ca.file, filepath.Join(dir, "ca-key.pem"), der, key)
+	return ca
+}
+
+// issue writes a certificate signed by the CA and returns its files.
+func (ca *testCA) issue(t *testing.T, name string, subject pkix.Name, usage x509.ExtKeyUsage) (string, string, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed to issue certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	certFile := filepath.Join(ca.dir, name+".pem")
+	keyFile := filepath.Join(ca.dir, name+"-key.pem")
+	writeCertPair(t, certFile, keyFile, der, key)
+	return certFile, keyFile, cert
+}
+
+func TestE2E_MutualTLSAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	serverCert, serverKey, _ := ca.issue(t, "server", pkix.Name{CommonName: "s3up-server"}, x509.ExtKeyUsageServerAuth)
+	web1Cert, web1Key, _ := ca.issue(t, "web1", pkix.Name{CommonName: "web1", Organization: []string{"Example"}}, x509.ExtKeyUsageClientAuth)
+	web2Cert, web2Key, web2 := ca.issue(t, "web2", pkix.Name{CommonName: "web2"}, x509.ExtKeyUsageClientAuth)
+	strangerCert, strangerKey, _ := ca.issue(t, "stranger", pkix.Name{CommonName: "stranger"}, x509.ExtKeyUsageClientAuth)
+
+	// Fingerprints are accepted in any case, colon-separated or not.
+	fingerprint := server.CertFingerprint(web2)
+	clientsFile := filepath.Join(dir, "clients.yaml")
+	os.WriteFile(clientsFile, []byte(`clients:
+  - id: web1
+    cert_subject: "CN=web1,O=Example"
+  - id: web2
+    cert_fingerprint: "sha256:`+strings.ToUpper(fingerprint[:2]+":"+fingerprint[2:])+`"
+  - id: legacy
+    api_key: legacy-key
+`), 0644)
+	clients, err := server.LoadClientsConfig(clientsFile)
+	if err != nil {
+		t.Fatalf("failed to load clients config: %v", err)
+	}
+
+	certs, err := server.NewCertReloader(serverCert, serverKey)
+	if err != nil {
+		t.Fatalf("failed to load server certificate: %v", err)
+	}
+	tlsConfig, err := server.NewTLSConfig(server.TLSConfig{ClientCAFile: ca.file}, certs)
+	if err != nil {
+		t.Fatalf("failed to build tls config: %v", err)
+	}
+	storage := server.NewFakeStorage(filepath.Join(dir, "storage"), "backups")
+	mux := http.NewServeMux()
+	server.NewHandler(storage, nil).RegisterRoutes(mux, server.NewAuthMiddleware(clients))
+	addr, stop := startTLSServer(t, tlsConfig, mux)
+	defer stop()
+
+	uploaderFor := func(certFile, keyFile, apiKey string) *client.Uploader {
+		cfg := &client.Config{
+			Server: client.ServerConfig{URL: "https://" + addr, APIKey: apiKey, CertFile: certFile, KeyFile: keyFile, CAFile: ca.file},
+			Upload: client.UploadConfig{MaxFileSizeMB: 100},
+		}
+		return client.NewUploader(cfg, nil)
+	}
+
+	localPath := filepath.Join(dir, "a.txt")
+	os.WriteFile(localPath, []byte("hello"), 0644)
+
+	if _, err := uploaderFor(web1Cert, web1Key, "").Upload(localPath, "docs/a.txt"); err != nil {
+		t.Fatalf("upload with a certificate matched by subject failed: %v", err)
+	}
+	if !fileExists(filepath.Join(dir, "storage", "backups", "web1", "docs", "a.txt")) {
+		t.Errorf("upload should be stored under the web1 client")
+	}
+
+	if _, err := uploaderFor(web2Cert, web2Key, "").Exists("docs/a.txt"); err != nil {
+		t.Errorf("request with a certificate matched by fingerprint failed: %v", err)
+	}
+
+	if _, err := uploaderFor("", "", "legacy-key").Exists("docs/a.txt"); err != nil {
+		t.Errorf("api keys must keep working next to certificates: %v", err)
+	}
+
+	if _, err := uploaderFor(web1Cert, web1Key, "legacy-key").Exists("docs/a.txt"); !isUnauthorized(err) {
+		t.Errorf("a certificate and api key of different clients must be refused, got %v", err)
+	}
+
+	if _, err := uploaderFor(strangerCert, strangerKey, "").Exists("docs/a.txt"); !isUnauthorized(err) {
+		t.Errorf("an unknown certificate must be refused, got %v", err)
+	}
+}
+
+func isUnauthorized(err error) bool {
+	var statusErr *client.StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusUnauthorized
+}
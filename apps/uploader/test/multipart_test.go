@@ -0,0 +1,213 @@
+package test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"s3uploader/internal/client"
+	"s3uploader/internal/server"
+)
+
+func writeRandomFile(t *testing.T, path string, size int) {
+	t.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("failed to generate random data: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("failed to write file %s: %v", path, err)
+	}
+}
+
+func TestE2E_MultipartUploadResume(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.Upload.ChunkSizeMB = 5
+	env.uploader = client.NewUploader(env.cfg, env.db)
+
+	localPath := filepath.Join(env.watchDir, "dump.sql")
+	writeRandomFile(t, localPath, 12<<20)
+
+	var mu sync.Mutex
+	partCalls := make(map[string]int)
+	failPart := "2"
+	inner := env.ts.Config.Handler
+	env.ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/multipart/part" {
+			n := r.URL.Query().Get("part_number")
+			mu.Lock()
+			partCalls[n]++
+			fail := n == failPart
+			if fail {
+				failPart = ""
+			}
+			mu.Unlock()
+			if fail {
+				http.Error(w, "simulated failure", http.StatusInternalServerError)
+				return
+			}
+		}
+		inner.ServeHTTP(w, r)
+	})
+
+	if _, err := env.uploader.Upload(localPath, "uploads/dump.sql"); err == nil {
+		t.Fatalf("expected first upload to fail")
+	}
+
+	rec, err := env.db.GetMultipartUpload(localPath)
+	if err != nil {
+		t.Fatalf("db error: %v", err)
+	}
+	if rec == nil || len(rec.Parts) != 1 {
+		t.Fatalf("expected 1 recorded part after interruption, got %+v", rec)
+	}
+
+	resp, err := env.uploader.Upload(localPath, "uploads/dump.sql")
+	if err != nil {
+		t.Fatalf("resumed upload failed: %v", err)
+	}
+	if resp.Size != 12<<20 {
+		t.Errorf("expected size %d, got %d", 12<<20, resp.Size)
+	}
+
+	if partCalls["1"] != 1 {
+		t.Errorf("part 1 should be sent once, was sent %d times", partCalls["1"])
+	}
+	if partCalls["3"] != 1 {
+		t.Errorf("part 3 should be sent once, was sent %d times", partCalls["3"])
+	}
+
+	rec, err = env.db.GetMultipartUpload(localPath)
+	if err != nil {
+		t.Fatalf("db error: %v", err)
+	}
+	if rec != nil {
+		t.Errorf("multipart state should be cleared after completion")
+	}
+
+	storagePath := env.storage.GetFilePath("test-client", "uploads/dump.sql")
+	if hashFile(t, storagePath) != hashFile(t, localPath) {
+		t.Errorf("hash mismatch for multipart upload")
+	}
+}
+
+func TestE2E_MultipartCompleteRecordsVerifiedSize(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	serverDB, err := server.NewDB(filepath.Join(env.tmpDir, "server.db"))
+	if err != nil {
+		t.Fatalf("failed to create server db: %v", err)
+	}
+	defer serverDB.Close()
+
+	auth := server.NewAuthMiddleware([]server.ClientEntry{{ID: "test-client", APIKey: "test-api-key"}})
+	mux := http.NewServeMux()
+	server.NewHandler(env.storage, serverDB).RegisterRoutes(mux, auth)
+
+	// The first completion claims a size that does not match what was
+	// uploaded.
+	var lie atomic.Bool
+	lie.Store(true)
+	env.ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/multipart/complete" && lie.Load() {
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			body["size"] = 1
+			data, _ := json.Marshal(body)
+			r.Body = io.NopCloser(bytes.NewReader(data))
+			r.ContentLength = int64(len(data))
+		}
+		mux.ServeHTTP(w, r)
+	})
+
+	env.cfg.Upload.ChunkSizeMB = 5
+	env.uploader = client.NewUploader(env.cfg, env.db)
+	localPath := filepath.Join(env.watchDir, "dump.sql")
+	writeRandomFile(t, localPath, 11<<20)
+	if _, err := env.uploader.Upload(localPath, "uploads/dump.sql"); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+
+	records, err := serverDB.LatestUploads("test-client", "uploads", time.Now().Unix()+1)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("expected one upload record, got %+v, %v", records, err)
+	}
+	if records[0].FileSize != 11<<20 {
+		t.Errorf("expected the stored size %d, got %d", 11<<20, records[0].FileSize)
+	}
+	if records[0].SHA256 != nil {
+		t.Errorf("a completion with the wrong size must not record a checksum, got %q", *records[0].SHA256)
+	}
+
+	lie.Store(false)
+	resp, err := env.uploader.Upload(localPath, "uploads/dump.sql")
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	expected := hashFile(t, localPath)
+	if resp.SHA256 != expected {
+		t.Errorf("expected the whole-file sha256 %s, got %s", expected, resp.SHA256)
+	}
+	stat, err := env.uploader.Stat("uploads/dump.sql")
+	if err != nil || stat.SHA256 != expected {
+		t.Errorf("expected /exists to report the whole-file sha256 %s, got %+v, %v", expected, stat, err)
+	}
+}
+
+func TestE2E_MultipartDetectsChangeAfterPartsSent(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.Upload.ChunkSizeMB = 5
+	env.uploader = client.NewUploader(env.cfg, env.db)
+	localPath := filepath.Join(env.watchDir, "dump.sql")
+	writeRandomFile(t, localPath, 11<<20)
+	info, err := os.Stat(localPath)
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+
+	// Part 1 is rewritten in place, size and mtime unchanged, once the
+	// last part has been sent.
+	var completes atomic.Int32
+	inner := env.ts.Config.Handler
+	env.ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inner.ServeHTTP(w, r)
+		if r.URL.Path == "/multipart/part" && r.URL.Query().Get("part_number") == "3" {
+			f, err := os.OpenFile(localPath, os.O_WRONLY, 0)
+			if err != nil {
+				t.Errorf("failed to open file: %v", err)
+				return
+			}
+			f.WriteAt([]byte("changed"), 0)
+			f.Close()
+			os.Chtimes(localPath, info.ModTime(), info.ModTime())
+		}
+		if r.URL.Path == "/multipart/complete" {
+			completes.Add(1)
+		}
+	})
+
+	_, err = env.uploader.Upload(localPath, "uploads/dump.sql")
+	if !errors.Is(err, client.ErrChecksumMismatch) {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	if completes.Load() != 0 {
+		t.Errorf("an upload whose parts no longer match the file must not be completed")
+	}
+	if rec, _ := env.db.GetMultipartUpload(localPath); rec != nil {
+		t.Errorf("multipart state should be discarded so the next attempt starts over, got %+v", rec)
+	}
+}
@@ -0,0 +1,101 @@
+package test
+
+import (
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestE2E_ServerOutagePausesQueue(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.Upload.RetryAttempts = 1
+	env.cfg.Upload.OutageProbeInitialSeconds = 1
+	env.cfg.Upload.OutageProbeMaxSeconds = 1
+
+	var down atomic.Bool
+	down.Store(true)
+	var healthProbes atomic.Int32
+	inner := env.ts.Config.Handler
+	env.ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			healthProbes.Add(1)
+		}
+		if down.Load() {
+			http.Error(w, "simulated outage", http.StatusServiceUnavailable)
+			return
+		}
+		inner.ServeHTTP(w, r)
+	})
+
+	testFiles := generateRandomFiles(t, env.watchDir, 5)
+	for localPath := range testFiles {
+		env.queue.Enqueue(localPath, filepath.Join("uploads", filepath.Base(localPath)))
+	}
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	time.Sleep(3 * time.Second)
+	if healthProbes.Load() == 0 {
+		t.Errorf("expected the client to probe /health during the outage")
+	}
+	down.Store(false)
+
+	waitForUploads(t, env.db, testFiles, 20*time.Second)
+
+	failed, err := env.db.ListFailedUploads()
+	if err != nil {
+		t.Fatalf("db error: %v", err)
+	}
+	if len(failed) != 0 {
+		t.Errorf("server outage should not mark files failed, got %d failed uploads", len(failed))
+	}
+}
+
+func TestE2E_ServerErrorWithHealthyServerFailsFile(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.Upload.RetryAttempts = 2
+
+	inner := env.ts.Config.Handler
+	env.ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/upload" {
+			http.Error(w, "simulated failure", http.StatusInternalServerError)
+			return
+		}
+		inner.ServeHTTP(w, r)
+	})
+
+	testFiles := generateRandomFiles(t, env.watchDir, 1)
+	var localPath string
+	for p := range testFiles {
+		localPath = p
+	}
+	env.queue.Enqueue(localPath, filepath.Join("uploads", filepath.Base(localPath)))
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		failed, err := env.db.GetFailedUpload(localPath)
+		if err != nil {
+			t.Fatalf("db error: %v", err)
+		}
+		if failed != nil {
+			if failed.NextRetryAt == 0 {
+				t.Errorf("a 5xx should stay retryable, got %+v", failed)
+			}
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("a file the server keeps rejecting with 5xx should be recorded as failed")
+}
@@ -0,0 +1,108 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"s3uploader/internal/client"
+)
+
+func TestE2E_PersistentQueueSurvivesRestart(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	queue, err := client.NewPersistentQueue(env.db)
+	if err != nil {
+		t.Fatalf("failed to load queue: %v", err)
+	}
+
+	testFiles := generateRandomFiles(t, env.watchDir, 3)
+	for localPath := range testFiles {
+		queue.EnqueueWithAttempts(localPath, filepath.Join("uploads", filepath.Base(localPath)), 2)
+	}
+
+	// An entry handed out but never released must also survive.
+	inFlight, ok := queue.Dequeue()
+	if !ok {
+		t.Fatalf("expected an entry to dequeue")
+	}
+
+	env.db.Close()
+	db, err := client.NewDB(env.dbPath)
+	if err != nil {
+		t.Fatalf("failed to reopen db: %v", err)
+	}
+	env.db = db
+
+	restored, err := client.NewPersistentQueue(env.db)
+	if err != nil {
+		t.Fatalf("failed to reload queue: %v", err)
+	}
+	if restored.Len() != len(testFiles) {
+		t.Fatalf("expected %d restored entries, got %d", len(testFiles), restored.Len())
+	}
+	if !restored.Contains(inFlight.LocalPath) {
+		t.Errorf("in-flight entry %s was not restored", inFlight.LocalPath)
+	}
+
+	entry, _ := restored.Dequeue()
+	if entry.AttemptCount != 2 {
+		t.Errorf("expected attempt count 2 to be restored, got %d", entry.AttemptCount)
+	}
+	restored.EnqueueWithAttempts(entry.LocalPath, entry.RemotePath, entry.AttemptCount)
+	restored.Done(entry.LocalPath)
+
+	uploader := client.NewUploader(env.cfg, env.db)
+	processor := client.NewProcessor(restored, env.db, uploader, env.cfg)
+
+	stopProcessor := make(chan struct{})
+	go processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	waitForUploads(t, env.db, testFiles, 30*time.Second)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		entries, err := env.db.LoadQueue()
+		if err != nil {
+			t.Fatalf("db error: %v", err)
+		}
+		if len(entries) == 0 {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Errorf("queue table should be empty once all uploads are done")
+}
+
+func TestE2E_ForcedQueueEntrySurvivesRestart(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	queue, err := client.NewPersistentQueue(env.db)
+	if err != nil {
+		t.Fatalf("failed to load queue: %v", err)
+	}
+
+	// A reupload of an already queued file marks the queued entry forced.
+	queue.Enqueue("/data/a.txt", "uploads/a.txt")
+	queue.EnqueueEntry(client.QueueEntry{LocalPath: "/data/a.txt", RemotePath: "uploads/a.txt", Force: true})
+	queue.Enqueue("/data/b.txt", "uploads/b.txt")
+
+	restored, err := client.NewPersistentQueue(env.db)
+	if err != nil {
+		t.Fatalf("failed to reload queue: %v", err)
+	}
+	forced := make(map[string]bool)
+	for {
+		entry, ok := restored.Dequeue()
+		if !ok {
+			break
+		}
+		forced[entry.LocalPath] = entry.Force
+	}
+	if len(forced) != 2 || !forced["/data/a.txt"] || forced["/data/b.txt"] {
+		t.Errorf("expected only a.txt to be restored as forced, got %v", forced)
+	}
+}
@@ -0,0 +1,78 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"s3uploader/internal/client"
+)
+
+func TestE2E_PeriodicRescanReconciles(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	old := time.Now().Add(-time.Hour)
+	preExisting := generateRandomFilesWithPrefix(t, env.watchDir, 2, "old_")
+	for localPath := range preExisting {
+		if err := os.Chtimes(localPath, old, old); err != nil {
+			t.Fatalf("failed to set mtime: %v", err)
+		}
+	}
+
+	reconciler := client.NewReconciler(env.queue, env.db, env.cfg)
+	// File timestamps come from a coarser clock than time.Now.
+	time.Sleep(50 * time.Millisecond)
+
+	// Files appearing while the watcher is not looking.
+	missed := generateRandomFilesWithPrefix(t, env.watchDir, 3, "missed_")
+
+	queued, err := reconciler.Reconcile()
+	if err != nil {
+		t.Fatalf("reconcile failed: %v", err)
+	}
+	if queued != len(missed) {
+		t.Fatalf("expected %d missed files queued, got %d", len(missed), queued)
+	}
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	waitForUploads(t, env.db, missed, 30*time.Second)
+
+	if queued, err = reconciler.Reconcile(); err != nil {
+		t.Fatalf("reconcile failed: %v", err)
+	}
+	if queued != 0 {
+		t.Errorf("uploaded files should not be queued again, got %d", queued)
+	}
+
+	var changedPath string
+	for p := range missed {
+		changedPath = p
+		break
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.WriteFile(changedPath, []byte("changed content"), 0644); err != nil {
+		t.Fatalf("failed to modify file: %v", err)
+	}
+	if err := os.Chtimes(changedPath, later, later); err != nil {
+		t.Fatalf("failed to set mtime: %v", err)
+	}
+
+	if queued, err = reconciler.Reconcile(); err != nil {
+		t.Fatalf("reconcile failed: %v", err)
+	}
+	if queued != 1 {
+		t.Errorf("expected only the changed file to be queued, got %d", queued)
+	}
+
+	for localPath := range preExisting {
+		rel, _ := filepath.Rel(env.watchDir, localPath)
+		if _, err := os.Stat(env.storage.GetFilePath("test-client", filepath.Join("uploads", rel))); err == nil {
+			t.Errorf("pre-existing file %s should not be uploaded without upload_existing", localPath)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestE2E_RemoteListGetDeletePrefix(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	contents := map[string]string{
+		"old/a.txt":        "a",
+		"old/nested/b.txt": "bb",
+		"keep/c.txt":       "ccc",
+	}
+	for name, content := range contents {
+		localPath := filepath.Join(env.watchDir, name)
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(localPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		if _, err := env.uploader.Upload(localPath, "uploads/"+name); err != nil {
+			t.Fatalf("upload failed: %v", err)
+		}
+	}
+
+	files, err := env.uploader.List("uploads/old")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(files) != 2 {
+		t.Errorf("expected 2 files under uploads/old, got %+v", files)
+	}
+
+	var buf bytes.Buffer
+	if _, err := env.uploader.Download("uploads/old/nested/b.txt", "", &buf); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if buf.String() != "bb" {
+		t.Errorf("downloaded %q, expected %q", buf.String(), "bb")
+	}
+
+	deleted, err := env.uploader.DeletePrefix("uploads/old")
+	if err != nil {
+		t.Fatalf("delete prefix failed: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("expected 2 files deleted, got %d", deleted)
+	}
+
+	files, err = env.uploader.List("uploads")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(files) != 1 || files[0].Path != "uploads/keep/c.txt" {
+		t.Errorf("only uploads/keep/c.txt should remain, got %+v", files)
+	}
+}
+
+func TestE2E_FakeDeletePrefixReportsPartialCount(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if _, err := env.storage.Upload(context.Background(), "test-client", "docs/"+name, strings.NewReader(name), int64(len(name)), ""); err != nil {
+			t.Fatalf("upload failed: %v", err)
+		}
+	}
+
+	// An object whose delete marker cannot be written, because a file sits
+	// where its versions directory belongs.
+	locked := env.storage.GetFilePath("test-client", "docs/locked.txt")
+	if err := os.WriteFile(locked, []byte("locked"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	baseDir := filepath.Dir(filepath.Dir(env.storage.GetFilePath("test-client", "")))
+	versions := filepath.Join(baseDir, ".versions", "backups", "test-client", "docs", "locked.txt")
+	if err := os.MkdirAll(filepath.Dir(versions), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(versions, nil, 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	deleted, err := env.storage.DeletePrefix(context.Background(), "test-client", "docs")
+	if err == nil {
+		t.Errorf("expected an error for the object that could not be deleted")
+	}
+	if deleted != 2 {
+		t.Errorf("expected the 2 deleted objects to be counted, got %d", deleted)
+	}
+	if !fileExists(locked) {
+		t.Errorf("an object that could not be deleted should be left in place")
+	}
+}
@@ -0,0 +1,191 @@
+package test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"s3uploader/internal/client"
+	"s3uploader/internal/server"
+)
+
+func TestE2E_DailyReports(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	serverDB, err := server.NewDB(filepath.Join(env.tmpDir, "server.db"))
+	if err != nil {
+		t.Fatalf("failed to create server db: %v", err)
+	}
+	defer serverDB.Close()
+
+	var serverDown atomic.Bool
+	mux := http.NewServeMux()
+	server.NewHandler(env.storage, serverDB).RegisterRoutes(mux, server.NewAuthMiddleware([]server.ClientEntry{
+		{ID: "test-client", APIKey: "test-api-key"},
+	}))
+	env.ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/report" && serverDown.Load() {
+			http.Error(w, "simulated outage", http.StatusServiceUnavailable)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+
+	testFiles := generateRandomFiles(t, env.watchDir, 3)
+	var totalBytes int64
+	for localPath := range testFiles {
+		env.queue.Enqueue(localPath, filepath.Join("uploads", filepath.Base(localPath)))
+	}
+	if hw := env.queue.TakeHighWater(); hw != 3 {
+		t.Errorf("expected a queue high-water mark of 3, got %d", hw)
+	}
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+	waitForUploads(t, env.db, testFiles, 30*time.Second)
+
+	for localPath := range testFiles {
+		rec, _ := env.db.GetFile(localPath)
+		totalBytes += rec.FileSize
+	}
+	today, err := env.db.GetDailyStats(time.Now().Format("2006-01-02"))
+	if err != nil {
+		t.Fatalf("db error: %v", err)
+	}
+	if today == nil || today.FilesUploaded != 3 || today.BytesUploaded != totalBytes {
+		t.Errorf("expected 3 files and %d bytes uploaded today, got %+v", totalBytes, today)
+	}
+
+	// Two days the daemon recorded but never got to report.
+	env.db.AddDailyStats(client.DailyStats{Day: "2026-01-01", FilesUploaded: 5, BytesUploaded: 500, QueueHighWater: 7})
+	env.db.AddDailyStats(client.DailyStats{Day: "2026-01-02", FilesFailed: 1})
+
+	reporter := client.NewReporter(env.queue, env.db, env.uploader)
+
+	serverDown.Store(true)
+	if _, err := reporter.SendPending(); err == nil {
+		t.Fatalf("expected the report to fail while the server is down")
+	}
+
+	serverDown.Store(false)
+	sent, err := reporter.SendPending()
+	if err != nil {
+		t.Fatalf("report failed: %v", err)
+	}
+	if sent != 2 {
+		t.Errorf("expected the 2 finished days to be sent, got %d", sent)
+	}
+	if sent, _ := reporter.SendPending(); sent != 0 {
+		t.Errorf("reported days must not be sent again, got %d", sent)
+	}
+
+	reports, err := serverDB.ListReports("test-client")
+	if err != nil {
+		t.Fatalf("server db error: %v", err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("expected 2 stored reports, got %+v", reports)
+	}
+	if r := reports[1]; r.Day != "2026-01-01" || r.FilesUploaded != 5 || r.BytesUploaded != 500 || r.QueueHighWater != 7 {
+		t.Errorf("unexpected stored report: %+v", r)
+	}
+	if reports[0].FilesFailed != 1 {
+		t.Errorf("unexpected stored report: %+v", reports[0])
+	}
+}
+
+func waitForFailures(t *testing.T, env *testEnv, localPath string, attempts int, timeout time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		failed, err := env.db.GetFailedUpload(localPath)
+		if err != nil {
+			t.Fatalf("db error: %v", err)
+		}
+		if failed != nil && failed.AttemptCount >= attempts {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d failures of %s", attempts, localPath)
+}
+
+func TestE2E_DailyStatsCountEachFileOnce(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.Watches[0].SkipUnchanged = true
+
+	var reject atomic.Bool
+	inner := env.ts.Config.Handler
+	env.ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/upload" && reject.Load() {
+			http.Error(w, "simulated rejection", http.StatusForbidden)
+			return
+		}
+		inner.ServeHTTP(w, r)
+	})
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	testFiles := generateRandomFiles(t, env.watchDir, 2)
+	var failing, unchanged string
+	for localPath := range testFiles {
+		if failing == "" {
+			failing = localPath
+		} else {
+			unchanged = localPath
+		}
+	}
+
+	// A file that fails twice in a day is one failed file.
+	reject.Store(true)
+	env.queue.Enqueue(failing, filepath.Join("uploads", filepath.Base(failing)))
+	waitForFailures(t, env, failing, 1, 10*time.Second)
+	touched := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(failing, touched, touched); err != nil {
+		t.Fatalf("failed to touch file: %v", err)
+	}
+	env.queue.Enqueue(failing, filepath.Join("uploads", filepath.Base(failing)))
+	waitForFailures(t, env, failing, 2, 10*time.Second)
+	reject.Store(false)
+
+	// A file touched twice without a content change is one skipped file.
+	env.queue.Enqueue(unchanged, filepath.Join("uploads", filepath.Base(unchanged)))
+	waitForUploads(t, env.db, map[string]string{unchanged: testFiles[unchanged]}, 30*time.Second)
+	for i := 1; i <= 2; i++ {
+		mtime := time.Now().Add(time.Duration(i) * time.Hour).Truncate(time.Second)
+		if err := os.Chtimes(unchanged, mtime, mtime); err != nil {
+			t.Fatalf("failed to touch file: %v", err)
+		}
+		env.queue.Enqueue(unchanged, filepath.Join("uploads", filepath.Base(unchanged)))
+		waitForMtime(t, env, unchanged, mtime.Unix(), 10*time.Second)
+	}
+
+	// Baselined files are skipped too, but only when first recorded.
+	baselined := generateRandomFilesWithPrefix(t, env.watchDir, 3, "baseline_")
+	var recs []client.FileRecord
+	for localPath := range baselined {
+		recs = append(recs, client.FileRecord{LocalPath: localPath, RemotePath: filepath.Base(localPath)})
+	}
+	for i := 0; i < 2; i++ {
+		if err := env.db.InsertBaseline(env.watchDir, recs); err != nil {
+			t.Fatalf("db error: %v", err)
+		}
+	}
+
+	today, err := env.db.GetDailyStats(time.Now().Format("2006-01-02"))
+	if err != nil {
+		t.Fatalf("db error: %v", err)
+	}
+	if today == nil || today.FilesFailed != 1 || today.FilesSkipped != 4 || today.FilesUploaded != 1 {
+		t.Errorf("expected 1 failed, 4 skipped and 1 uploaded file today, got %+v", today)
+	}
+}
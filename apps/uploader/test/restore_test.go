@@ -0,0 +1,176 @@
+package test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"s3uploader/internal/client"
+	"s3uploader/internal/server"
+)
+
+func TestE2E_PointInTimeRestore(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	serverDB, err := server.NewDB(filepath.Join(env.tmpDir, "server.db"))
+	if err != nil {
+		t.Fatalf("failed to create server db: %v", err)
+	}
+	defer serverDB.Close()
+
+	var failDownloads atomic.Bool
+	var versionRequests atomic.Int32
+	mux := http.NewServeMux()
+	server.NewHandler(env.storage, serverDB).RegisterRoutes(mux, server.NewAuthMiddleware([]server.ClientEntry{
+		{ID: "test-client", APIKey: "test-api-key"},
+	}))
+	env.ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/versions" {
+			versionRequests.Add(1)
+		}
+		if r.URL.Path == "/download" && strings.HasSuffix(r.URL.Query().Get("path"), "b.txt") && failDownloads.Load() {
+			http.Error(w, "simulated failure", http.StatusInternalServerError)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+
+	upload := func(name, content string) {
+		t.Helper()
+		localPath := filepath.Join(env.watchDir, name)
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(localPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		if _, err := env.uploader.Upload(localPath, "uploads/"+name); err != nil {
+			t.Fatalf("upload failed: %v", err)
+		}
+	}
+
+	upload("a.txt", "a version one")
+	upload("sub/b.txt", "b version one")
+	time.Sleep(1100 * time.Millisecond)
+	restoreAt := time.Now()
+	time.Sleep(1100 * time.Millisecond)
+	upload("a.txt", "a version two")
+	upload("c.txt", "c created later")
+	if err := env.uploader.Delete("uploads/sub/b.txt"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	dest := filepath.Join(env.tmpDir, "restore")
+	opts := client.RestoreOptions{Watch: &env.cfg.Watches[0], At: restoreAt, Dest: dest, Parallel: 2}
+
+	failDownloads.Store(true)
+	report, err := client.Restore(env.uploader, opts)
+	if err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if report.Restored != 1 || report.Failed != 1 || report.Skipped != 1 {
+		t.Fatalf("expected 1 restored, 1 failed, 1 skipped, got %+v", report)
+	}
+	if n := versionRequests.Load(); n != 1 {
+		t.Errorf("restore should list versions once for the whole prefix, got %d requests", n)
+	}
+
+	failDownloads.Store(false)
+	report, err = client.Restore(env.uploader, opts)
+	if err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	statuses := make(map[string]string)
+	for _, r := range report.Files {
+		statuses[r.Path] = r.Status
+	}
+	expected := map[string]string{
+		"uploads/a.txt":     client.RestoreAlreadyRestored,
+		"uploads/sub/b.txt": client.RestoreRestored,
+		"uploads/c.txt":     client.RestoreSkipped,
+	}
+	for path, status := range expected {
+		if statuses[path] != status {
+			t.Errorf("%s: expected status %s, got %q", path, status, statuses[path])
+		}
+	}
+
+	assertContent(t, filepath.Join(dest, "a.txt"), "a version one")
+	assertContent(t, filepath.Join(dest, "sub", "b.txt"), "b version one")
+	if fileExists(filepath.Join(dest, "c.txt")) {
+		t.Errorf("c.txt did not exist at restore time and should not be restored")
+	}
+
+	latest := filepath.Join(env.tmpDir, "latest")
+	report, err = client.Restore(env.uploader, client.RestoreOptions{Watch: &env.cfg.Watches[0], At: time.Now(), Dest: latest})
+	if err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	assertContent(t, filepath.Join(latest, "a.txt"), "a version two")
+	assertContent(t, filepath.Join(latest, "c.txt"), "c created later")
+	if fileExists(filepath.Join(latest, "sub", "b.txt")) {
+		t.Errorf("deleted b.txt should not be restored at the current time")
+	}
+}
+
+func TestE2E_LatestUploadsPicksWholeRow(t *testing.T) {
+	serverDB, err := server.NewDB(filepath.Join(t.TempDir(), "server.db"))
+	if err != nil {
+		t.Fatalf("failed to create server db: %v", err)
+	}
+	defer serverDB.Close()
+
+	// Both uploads land in the same second; the later row must win with
+	// its own size and checksum.
+	for i, sum := range []string{strings.Repeat("a", 64), strings.Repeat("b", 64)} {
+		if err := serverDB.InsertUpload("test-client", "uploads/a.txt", int64(i+1), sum); err != nil {
+			t.Fatalf("db error: %v", err)
+		}
+	}
+	if err := serverDB.InsertUpload("test-client", "uploads/b.txt", 7, ""); err != nil {
+		t.Fatalf("db error: %v", err)
+	}
+
+	records, err := serverDB.LatestUploads("test-client", "uploads/", time.Now().Unix()+1)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("expected two records, got %+v, %v", records, err)
+	}
+	a := records[0]
+	if a.RemotePath != "uploads/a.txt" || a.FileSize != 2 || a.SHA256 == nil || *a.SHA256 != strings.Repeat("b", 64) {
+		t.Errorf("expected the newest upload of a.txt, got %+v", a)
+	}
+	if records[1].RemotePath != "uploads/b.txt" || records[1].FileSize != 7 {
+		t.Errorf("unexpected record %+v", records[1])
+	}
+
+	for _, path := range []string{"photos/été/a.jpg", "photos/été/b.jpg", "photos/été2/c.jpg"} {
+		if err := serverDB.InsertUpload("test-client", path, 1, ""); err != nil {
+			t.Fatalf("db error: %v", err)
+		}
+	}
+	records, err = serverDB.LatestUploads("test-client", "photos/été", time.Now().Unix()+1)
+	if err != nil || len(records) != 2 || records[0].RemotePath != "photos/été/a.jpg" || records[1].RemotePath != "photos/été/b.jpg" {
+		t.Errorf("expected the 2 uploads below photos/été, got %+v, %v", records, err)
+	}
+	records, err = serverDB.LatestUploads("test-client", "photos/été/a.jpg", time.Now().Unix()+1)
+	if err != nil || len(records) != 1 {
+		t.Errorf("expected the upload of the exact path, got %+v, %v", records, err)
+	}
+}
+
+func assertContent(t *testing.T, path, expected string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Errorf("failed to read %s: %v", path, err)
+		return
+	}
+	if string(data) != expected {
+		t.Errorf("%s: expected %q, got %q", path, expected, string(data))
+	}
+}
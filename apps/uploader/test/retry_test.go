@@ -0,0 +1,193 @@
+package test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"s3uploader/internal/client"
+)
+
+func TestE2E_FailedUploadRetriedInProcess(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.Upload.RetryAttempts = 1
+	env.cfg.Upload.FailedRetryInitialSeconds = 1
+	env.cfg.Upload.FailedRetryMaxSeconds = 2
+
+	var failuresLeft atomic.Int32
+	failuresLeft.Store(2)
+	inner := env.ts.Config.Handler
+	env.ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/upload" && failuresLeft.Add(-1) >= 0 {
+			http.Error(w, "simulated failure", http.StatusInternalServerError)
+			return
+		}
+		inner.ServeHTTP(w, r)
+	})
+
+	testFiles := generateRandomFiles(t, env.watchDir, 1)
+	var localPath string
+	for p := range testFiles {
+		localPath = p
+	}
+	env.queue.Enqueue(localPath, filepath.Join("uploads", filepath.Base(localPath)))
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	deadline := time.Now().Add(10 * time.Second)
+	var failed *client.FailedUpload
+	for time.Now().Before(deadline) && failed == nil {
+		var err error
+		if failed, err = env.db.GetFailedUpload(localPath); err != nil {
+			t.Fatalf("db error: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if failed == nil {
+		t.Fatalf("expected failure to be recorded in failed_uploads")
+	}
+	if failed.Error == "" || failed.NextRetryAt == 0 {
+		t.Errorf("failed upload should record the error and next retry time, got %+v", failed)
+	}
+
+	scheduler := client.NewRetryScheduler(env.queue, env.db, env.cfg)
+	go scheduler.Run(stopProcessor)
+
+	waitForUploads(t, env.db, testFiles, 20*time.Second)
+
+	failed, err := env.db.GetFailedUpload(localPath)
+	if err != nil {
+		t.Fatalf("db error: %v", err)
+	}
+	if failed != nil {
+		t.Errorf("failed_uploads row should be removed after a successful retry")
+	}
+}
+
+func TestE2E_NonRetryableFailureNotRescheduled(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.Upload.RetryAttempts = 2
+	env.cfg.Upload.FailedRetryInitialSeconds = 1
+
+	inner := env.ts.Config.Handler
+	env.ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/upload" {
+			http.Error(w, "simulated rejection", http.StatusForbidden)
+			return
+		}
+		inner.ServeHTTP(w, r)
+	})
+
+	testFiles := generateRandomFiles(t, env.watchDir, 1)
+	var localPath string
+	for p := range testFiles {
+		localPath = p
+	}
+	env.queue.Enqueue(localPath, filepath.Join("uploads", filepath.Base(localPath)))
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	deadline := time.Now().Add(10 * time.Second)
+	var failed *client.FailedUpload
+	for time.Now().Before(deadline) && failed == nil {
+		var err error
+		if failed, err = env.db.GetFailedUpload(localPath); err != nil {
+			t.Fatalf("db error: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if failed == nil {
+		t.Fatalf("expected failure to be recorded in failed_uploads")
+	}
+	if failed.NextRetryAt != 0 || failed.AttemptCount != 1 {
+		t.Errorf("a rejected upload must not be scheduled for retry, got %+v", failed)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	queued, err := client.NewRetryScheduler(env.queue, env.db, env.cfg).RetryDue()
+	if err != nil || queued != 0 {
+		t.Errorf("expected nothing to retry, got %d, %v", queued, err)
+	}
+}
+
+func TestE2E_FailedRowClearedWhenNoUploadNeeded(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	testFiles := generateRandomFiles(t, env.watchDir, 1)
+	var localPath string
+	for p := range testFiles {
+		localPath = p
+	}
+	remotePath := filepath.Join("uploads", filepath.Base(localPath))
+	info, err := os.Stat(localPath)
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+
+	// The file is already recorded with its current mtime, e.g. because an
+	// earlier upload went through after this failure was saved.
+	if err := env.db.InsertFile(localPath, remotePath, info.Size(), info.ModTime().UTC().Unix(), nil, nil); err != nil {
+		t.Fatalf("db error: %v", err)
+	}
+	env.db.SaveFailedUpload(&client.FailedUpload{
+		LocalPath:    localPath,
+		RemotePath:   remotePath,
+		Error:        "boom",
+		AttemptCount: 1,
+		NextRetryAt:  time.Now().Unix(),
+	})
+
+	if env.processor.ProcessEntry(client.QueueEntry{LocalPath: localPath, RemotePath: remotePath}) {
+		t.Fatalf("an unchanged file should not be uploaded")
+	}
+	if failed, _ := env.db.GetFailedUpload(localPath); failed != nil {
+		t.Errorf("failed_uploads row should be cleared for a file that needs no upload")
+	}
+}
+
+func TestE2E_NoUploadAttemptsRecordsFailure(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	// LoadConfig rejects this; a processor handed it must still not crash.
+	env.cfg.Upload.RetryAttempts = -1
+
+	testFiles := generateRandomFiles(t, env.watchDir, 1)
+	var localPath string
+	for p := range testFiles {
+		localPath = p
+	}
+	env.queue.Enqueue(localPath, filepath.Join("uploads", filepath.Base(localPath)))
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	deadline := time.Now().Add(10 * time.Second)
+	var failed *client.FailedUpload
+	for time.Now().Before(deadline) && failed == nil {
+		var err error
+		if failed, err = env.db.GetFailedUpload(localPath); err != nil {
+			t.Fatalf("db error: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if failed == nil {
+		t.Fatalf("expected the file to be recorded as failed")
+	}
+	if rec, _ := env.db.GetFile(localPath); rec != nil {
+		t.Errorf("a file that was never uploaded must not be recorded as uploaded, got %+v", rec)
+	}
+}
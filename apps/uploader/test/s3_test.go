@@ -0,0 +1,260 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"s3uploader/internal/client"
+	"s3uploader/internal/server"
+)
+
+// fakeS3 is a plain-HTTP S3 endpoint that understands just enough of the
+// API for S3Client's upload paths, so the real SDK request path is tested.
+type fakeS3 struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	parts   map[string]map[string][]byte
+	nextID  int
+	// payloadHashes records the x-amz-content-sha256 header of each write.
+	payloadHashes []string
+	// locked keys are refused by DeleteObjects with a per-key error.
+	locked map[string]bool
+}
+
+func newFakeS3() *fakeS3 {
+	f := &fakeS3{objects: make(map[string][]byte), parts: make(map[string]map[string][]byte), locked: make(map[string]bool)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+func (f *fakeS3) object(key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[key]
+	return data, ok
+}
+
+func (f *fakeS3) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Path style: /<bucket>/<key>
+	var key string
+	if parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2); len(parts) == 2 {
+		key = parts[1]
+	}
+	q := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodGet && q.Get("list-type") == "2":
+		fmt.Fprint(w, `<ListBucketResult><IsTruncated>false</IsTruncated>`)
+		var keys []string
+		for k := range f.objects {
+			if strings.HasPrefix(k, q.Get("prefix")) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>%d</Size></Contents>`, k, len(f.objects[k]))
+		}
+		fmt.Fprint(w, `</ListBucketResult>`)
+
+	case r.Method == http.MethodPost && q.Has("delete"):
+		var req struct {
+			Objects []struct {
+				Key string
+			} `xml:"Object"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `<DeleteResult>`)
+		for _, o := range req.Objects {
+			if f.locked[o.Key] {
+				fmt.Fprintf(w, `<Error><Key>%s</Key><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`, o.Key)
+				continue
+			}
+			delete(f.objects, o.Key)
+			fmt.Fprintf(w, `<Deleted><Key>%s</Key></Deleted>`, o.Key)
+		}
+		fmt.Fprint(w, `</DeleteResult>`)
+
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.payloadHashes = append(f.payloadHashes, r.Header.Get("X-Amz-Content-Sha256"))
+		if uploadID := q.Get("uploadId"); uploadID != "" {
+			f.parts[uploadID][q.Get("partNumber")] = data
+		} else {
+			f.objects[key] = data
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, len(data)))
+
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		f.nextID++
+		uploadID := fmt.Sprintf("upload-%d", f.nextID)
+		f.parts[uploadID] = make(map[string][]byte)
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, key, uploadID)
+
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		parts := f.parts[q.Get("uploadId")]
+		var data []byte
+		for i := 1; i <= len(parts); i++ {
+			data = append(data, parts[fmt.Sprint(i)]...)
+		}
+		f.objects[key] = data
+		delete(f.parts, q.Get("uploadId"))
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Key>%s</Key></CompleteMultipartUploadResult>`, key)
+
+	case r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) client() *server.S3Client {
+	return server.NewS3Client(server.S3Config{
+		Endpoint:        f.URL,
+		Region:          "us-east-1",
+		Bucket:          "bucket",
+		PathPrefix:      "backups",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+	})
+}
+
+// newS3TestEnv is newTestEnv with the server storing into a fakeS3 over
+// plain HTTP through the real S3Client.
+func newS3TestEnv(t *testing.T) (*testEnv, *fakeS3) {
+	env := newTestEnv(t)
+	s3 := newFakeS3()
+	t.Cleanup(s3.Close)
+
+	storage := s3.client()
+	auth := server.NewAuthMiddleware([]server.ClientEntry{{ID: "test-client", APIKey: "test-api-key"}})
+	mux := http.NewServeMux()
+	server.NewHandler(storage, nil).RegisterRoutes(mux, auth)
+	env.ts.Config.Handler = mux
+	return env, s3
+}
+
+func TestE2E_S3UploadOverPlainHTTP(t *testing.T) {
+	env, s3 := newS3TestEnv(t)
+	defer env.cleanup()
+
+	localPath := filepath.Join(env.watchDir, "a.txt")
+	if err := os.WriteFile(localPath, []byte("hello over http"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if _, err := env.uploader.Upload(localPath, "docs/a.txt"); err != nil {
+		t.Fatalf("upload to a plain-HTTP S3 endpoint failed: %v", err)
+	}
+	if data, ok := s3.object("backups/test-client/docs/a.txt"); !ok || string(data) != "hello over http" {
+		t.Errorf("unexpected object %q, %v", data, ok)
+	}
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+	for _, h := range s3.payloadHashes {
+		if h != "UNSIGNED-PAYLOAD" {
+			t.Errorf("streamed bodies must be sent unsigned, got %q", h)
+		}
+	}
+}
+
+func TestE2E_S3MultipartOverPlainHTTP(t *testing.T) {
+	env, s3 := newS3TestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.Upload.ChunkSizeMB = 5
+	env.uploader = client.NewUploader(env.cfg, env.db)
+
+	content := make([]byte, 11*1024*1024)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	localPath := filepath.Join(env.watchDir, "big.bin")
+	if err := os.WriteFile(localPath, content, 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if _, err := env.uploader.Upload(localPath, "big.bin"); err != nil {
+		t.Fatalf("multipart upload to a plain-HTTP S3 endpoint failed: %v", err)
+	}
+	data, ok := s3.object("backups/test-client/big.bin")
+	if !ok || len(data) != len(content) || string(data) != string(content) {
+		t.Errorf("multipart object does not match the file (%d bytes, %v)", len(data), ok)
+	}
+}
+
+func TestE2E_UploadWithoutSizeField(t *testing.T) {
+	env, s3 := newS3TestEnv(t)
+	defer env.cleanup()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("path", "docs/nosize.txt")
+	fw, _ := mw.CreateFormFile("file", "nosize.txt")
+	fw.Write([]byte("no size field"))
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", env.ts.URL+"/upload", &buf)
+	req.Header.Set("Authorization", "Bearer test-api-key")
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload without size should succeed, got %d", resp.StatusCode)
+	}
+	if data, ok := s3.object("backups/test-client/docs/nosize.txt"); !ok || string(data) != "no size field" {
+		t.Errorf("unexpected object %q, %v", data, ok)
+	}
+}
+
+func TestE2E_S3DeletePrefixReportsPerKeyErrors(t *testing.T) {
+	s3 := newFakeS3()
+	defer s3.Close()
+
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		s3.objects["backups/test-client/docs/"+name] = []byte(name)
+	}
+	s3.locked["backups/test-client/docs/b.txt"] = true
+
+	deleted, err := s3.client().DeletePrefix(context.Background(), "test-client", "docs")
+	if err == nil {
+		t.Errorf("a key S3 refused to delete should be reported")
+	}
+	if deleted != 2 {
+		t.Errorf("expected the 2 deleted keys to be counted, got %d", deleted)
+	}
+	if _, ok := s3.object("backups/test-client/docs/b.txt"); !ok {
+		t.Errorf("the locked object should still exist")
+	}
+}
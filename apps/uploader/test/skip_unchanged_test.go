@@ -0,0 +1,91 @@
+package test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitForMtime(t *testing.T, env *testEnv, localPath string, mtime int64, timeout time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		rec, err := env.db.GetFile(localPath)
+		if err != nil {
+			t.Fatalf("db error: %v", err)
+		}
+		if rec != nil && rec.Mtime == mtime {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s to be recorded with mtime %d", localPath, mtime)
+}
+
+func TestE2E_SkipUnchangedContent(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.Watches[0].SkipUnchanged = true
+
+	var uploads atomic.Int32
+	inner := env.ts.Config.Handler
+	env.ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/upload" {
+			uploads.Add(1)
+		}
+		inner.ServeHTTP(w, r)
+	})
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	testFiles := generateRandomFiles(t, env.watchDir, 2)
+	var touched, rewritten string
+	for localPath := range testFiles {
+		if touched == "" {
+			touched = localPath
+		} else {
+			rewritten = localPath
+		}
+		env.queue.Enqueue(localPath, filepath.Join("uploads", filepath.Base(localPath)))
+	}
+
+	waitForUploads(t, env.db, testFiles, 30*time.Second)
+	if n := uploads.Load(); n != 2 {
+		t.Fatalf("expected 2 initial uploads, got %d", n)
+	}
+
+	newMtime := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(touched, newMtime, newMtime); err != nil {
+		t.Fatalf("failed to touch file: %v", err)
+	}
+	env.queue.Enqueue(touched, filepath.Join("uploads", filepath.Base(touched)))
+	waitForMtime(t, env, touched, newMtime.Unix(), 10*time.Second)
+
+	if n := uploads.Load(); n != 2 {
+		t.Errorf("touched file with unchanged content should not be re-uploaded, got %d uploads", n)
+	}
+
+	data, err := os.ReadFile(rewritten)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	data[0] ^= 0xff
+	if err := os.WriteFile(rewritten, data, 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.Chtimes(rewritten, newMtime, newMtime); err != nil {
+		t.Fatalf("failed to touch file: %v", err)
+	}
+	env.queue.Enqueue(rewritten, filepath.Join("uploads", filepath.Base(rewritten)))
+	waitForMtime(t, env, rewritten, newMtime.Unix(), 10*time.Second)
+
+	if n := uploads.Load(); n != 3 {
+		t.Errorf("changed file should be re-uploaded, got %d uploads", n)
+	}
+}
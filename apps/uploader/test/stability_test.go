@@ -0,0 +1,63 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"s3uploader/internal/client"
+)
+
+func TestE2E_StabilityChecksRunInParallel(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	testFiles := generateRandomFiles(t, env.watchDir, 8)
+
+	env.cfg.Scan.UploadExisting = true
+	scanner := client.NewScanner(env.queue, env.db, env.cfg)
+	if err := scanner.Scan(); err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	// A single worker that slept through each file's debounce window would
+	// need over 8 seconds here.
+	waitForUploads(t, env.db, testFiles, 4*time.Second)
+}
+
+func TestE2E_UnstableFileUploadedOnceSettled(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	stopProcessor := make(chan struct{})
+	go env.processor.Run(stopProcessor)
+	defer close(stopProcessor)
+
+	localPath := filepath.Join(env.watchDir, "growing.log")
+	f, err := os.Create(localPath)
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	env.queue.Enqueue(localPath, "uploads/growing.log")
+
+	for i := 0; i < 6; i++ {
+		if _, err := f.WriteString("line of log output\n"); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		time.Sleep(400 * time.Millisecond)
+	}
+	f.Close()
+
+	waitForUploads(t, env.db, map[string]string{localPath: ""}, 10*time.Second)
+
+	storagePath := env.storage.GetFilePath("test-client", "uploads/growing.log")
+	if hashFile(t, storagePath) != hashFile(t, localPath) {
+		t.Errorf("uploaded content does not match the settled file")
+	}
+}
@@ -0,0 +1,123 @@
+package test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"s3uploader/internal/client"
+)
+
+func TestE2E_StatusFromDatabase(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	testFiles := generateRandomFiles(t, env.watchDir, 2)
+	var uploadedBytes int64
+	for localPath := range testFiles {
+		rel, _ := filepath.Rel(env.watchDir, localPath)
+		remotePath := filepath.Join("uploads", rel)
+		resp, err := env.uploader.Upload(localPath, remotePath)
+		if err != nil {
+			t.Fatalf("upload failed: %v", err)
+		}
+		if err := env.db.InsertFile(localPath, remotePath, resp.Size, time.Now().Unix(), &resp.SHA256, nil); err != nil {
+			t.Fatalf("db error: %v", err)
+		}
+		uploadedBytes += resp.Size
+	}
+
+	tooLarge := "file_too_large"
+	env.db.InsertFile(filepath.Join(env.watchDir, "big.bin"), "uploads/big.bin", 1<<30, 1, nil, &tooLarge)
+	baseline := client.SkipReasonBaseline
+	env.db.InsertFile(filepath.Join(env.watchDir, "old1.bin"), "uploads/old1.bin", 10, 1, nil, &baseline)
+	env.db.InsertFile(filepath.Join(env.watchDir, "old2.bin"), "uploads/old2.bin", 10, 1, nil, &baseline)
+
+	for i, name := range []string{"a.bin", "b.bin"} {
+		env.db.SaveFailedUpload(&client.FailedUpload{
+			LocalPath:    filepath.Join(env.watchDir, name),
+			RemotePath:   "uploads/" + name,
+			Error:        "simulated failure",
+			AttemptCount: 1,
+			LastFailedAt: int64(100 + i),
+			NextRetryAt:  int64(200 + i),
+		})
+	}
+
+	queue, err := client.NewPersistentQueue(env.db)
+	if err != nil {
+		t.Fatalf("failed to load queue: %v", err)
+	}
+	queue.Enqueue(filepath.Join(env.watchDir, "pending.bin"), "uploads/pending.bin")
+
+	status, err := client.CollectStatus(env.db, env.cfg, 1)
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+
+	if status.Uploaded != 2 || status.UploadedBytes != uploadedBytes {
+		t.Errorf("expected 2 files and %d bytes uploaded, got %d and %d", uploadedBytes, status.Uploaded, status.UploadedBytes)
+	}
+	if status.Skipped["file_too_large"] != 1 || status.Skipped[client.SkipReasonBaseline] != 2 {
+		t.Errorf("unexpected skip counts: %v", status.Skipped)
+	}
+	if status.Queued != 1 {
+		t.Errorf("expected 1 queued file, got %d", status.Queued)
+	}
+	if len(status.Watches) != 1 || status.Watches[0].Uploaded != 2 || status.Watches[0].LastUploadedAt == nil {
+		t.Errorf("unexpected watch status: %+v", status.Watches)
+	}
+	if status.FailureCount != 2 || len(status.Failures) != 1 || status.Failures[0].LocalPath != filepath.Join(env.watchDir, "b.bin") {
+		t.Errorf("expected only the most recent of 2 failures, got %d: %+v", status.FailureCount, status.Failures)
+	}
+}
+
+func TestE2E_StatusCountsNonASCIIWatch(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.Watches[0].LocalPath = filepath.Join(env.tmpDir, "données")
+	localPath := filepath.Join(env.cfg.Watches[0].LocalPath, "a.bin")
+	if err := env.db.InsertFile(localPath, "uploads/a.bin", 10, time.Now().Unix(), nil, nil); err != nil {
+		t.Fatalf("db error: %v", err)
+	}
+
+	status, err := client.CollectStatus(env.db, env.cfg, 0)
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if len(status.Watches) != 1 || status.Watches[0].Uploaded != 1 || status.Watches[0].UploadedBytes != 10 {
+		t.Errorf("unexpected watch status: %+v", status.Watches)
+	}
+}
+
+func TestE2E_StatusOpensDatabaseReadOnly(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	missing := filepath.Join(env.tmpDir, "never-ran", "uploader.db")
+	if _, err := client.OpenDBReadOnly(missing); !errors.Is(err, client.ErrNoDatabase) {
+		t.Errorf("expected ErrNoDatabase, got %v", err)
+	}
+	if fileExists(filepath.Dir(missing)) {
+		t.Errorf("opening a missing database must not create anything")
+	}
+
+	if err := env.db.InsertFile(filepath.Join(env.watchDir, "a.bin"), "uploads/a.bin", 10, time.Now().Unix(), nil, nil); err != nil {
+		t.Fatalf("db error: %v", err)
+	}
+	db, err := client.OpenDBReadOnly(env.dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	status, err := client.CollectStatus(db, env.cfg, 0)
+	if err != nil || status.Uploaded != 1 {
+		t.Errorf("expected 1 uploaded file, got %+v, %v", status, err)
+	}
+	if err := db.InsertFile(filepath.Join(env.watchDir, "b.bin"), "uploads/b.bin", 10, time.Now().Unix(), nil, nil); err == nil {
+		t.Errorf("a read-only database must reject writes")
+	}
+}
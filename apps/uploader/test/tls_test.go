@@ -0,0 +1,140 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"s3uploader/internal/server"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 with the
+// given common name, key first so a watcher sees a mismatched pair in
+// between.
+func writeTestCert(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	writeCertPair(t, certFile, keyFile, der, key)
+}
+
+// writeCertPair writes a certificate and its key as PEM, key first.
+func writeCertPair(t *testing.T, certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+}
+
+// startTLSServer serves handler over TLS on a random local port.
+func startTLSServer(t *testing.T, tlsConfig *tls.Config, handler http.Handler) (string, func()) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := &http.Server{Handler: handler, TLSConfig: tlsConfig}
+	go srv.ServeTLS(ln, "", "")
+	return ln.Addr().String(), func() { srv.Close() }
+}
+
+func servedCommonName(addr string, clientConfig *tls.Config) (string, error) {
+	conn, err := tls.Dial("tcp", addr, clientConfig)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestE2E_TLSCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, "first")
+
+	certs, err := server.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("failed to load certificate: %v", err)
+	}
+	watcher, err := certs.Watch()
+	if err != nil {
+		t.Fatalf("failed to watch certificate: %v", err)
+	}
+	defer watcher.Close()
+
+	tlsConfig, err := server.NewTLSConfig(server.TLSConfig{MinVersion: "1.3"}, certs)
+	if err != nil {
+		t.Fatalf("failed to build tls config: %v", err)
+	}
+	addr, stop := startTLSServer(t, tlsConfig, http.NewServeMux())
+	defer stop()
+
+	clientConfig := &tls.Config{InsecureSkipVerify: true}
+	if cn, err := servedCommonName(addr, clientConfig); err != nil || cn != "first" {
+		t.Fatalf("expected the first certificate, got %q, %v", cn, err)
+	}
+
+	if _, err := servedCommonName(addr, &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12}); err == nil {
+		t.Errorf("a TLS 1.2 client must be refused when min_version is 1.3")
+	}
+
+	writeTestCert(t, certFile, keyFile, "second")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cn, err := servedCommonName(addr, clientConfig)
+		if err == nil && cn == "second" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("certificate was not reloaded, still serving %q, %v", cn, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestE2E_TLSConfigValidation(t *testing.T) {
+	certs := &server.CertReloader{}
+	if _, err := server.NewTLSConfig(server.TLSConfig{MinVersion: "1.0"}, certs); err == nil {
+		t.Errorf("expected min_version 1.0 to be rejected")
+	}
+	if _, err := server.NewTLSConfig(server.TLSConfig{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, certs); err == nil {
+		t.Errorf("expected an insecure cipher suite to be rejected")
+	}
+	cfg, err := server.NewTLSConfig(server.TLSConfig{CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}}, certs)
+	if err != nil || cfg.MinVersion != tls.VersionTLS12 || len(cfg.CipherSuites) != 1 {
+		t.Errorf("unexpected tls config %+v, %v", cfg, err)
+	}
+}
@@ -16,7 +16,7 @@ func TestE2E_UploadExisting_False(t *testing.T) {
 	preExistingFiles := generateRandomFiles(t, env.watchDir, 3)
 
 	env.cfg.Scan.UploadExisting = false
-	scanner := client.NewScanner(env.queue, env.cfg)
+	scanner := client.NewScanner(env.queue, env.db, env.cfg)
 	if err := scanner.Scan(); err != nil {
 		t.Fatalf("failed to scan: %v", err)
 	}
@@ -71,7 +71,7 @@ func TestE2E_UploadExisting_True(t *testing.T) {
 	preExistingFiles := generateRandomFiles(t, env.watchDir, 3)
 
 	env.cfg.Scan.UploadExisting = true
-	scanner := client.NewScanner(env.queue, env.cfg)
+	scanner := client.NewScanner(env.queue, env.db, env.cfg)
 	if err := scanner.Scan(); err != nil {
 		t.Fatalf("failed to scan: %v", err)
 	}
@@ -117,3 +117,38 @@ func TestE2E_UploadExisting_True(t *testing.T) {
 	t.Logf("upload_existing=true: all %d files (pre-existing + new) uploaded and verified",
 		len(allFiles))
 }
+
+func TestE2E_SkipModeCatchesFilesCreatedWhileDown(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	preExistingFiles := generateRandomFiles(t, env.watchDir, 3)
+	old := time.Now().Add(-time.Hour)
+	for localPath := range preExistingFiles {
+		if err := os.Chtimes(localPath, old, old); err != nil {
+			t.Fatalf("failed to set mtime: %v", err)
+		}
+	}
+
+	env.cfg.Scan.Mode = client.ScanModeSkip
+	if err := client.NewScanner(env.queue, env.db, env.cfg).Scan(); err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	if n := env.queue.Len(); n != 0 {
+		t.Fatalf("pre-existing files should be skipped on first start, got %d queued", n)
+	}
+
+	// The daemon is down while a file is created, then starts again.
+	newFiles := generateRandomFilesWithPrefix(t, env.watchDir, 1, "new_")
+	if err := client.NewScanner(env.queue, env.db, env.cfg).Scan(); err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	if n := env.queue.Len(); n != 1 {
+		t.Fatalf("expected only the file created while down to be queued, got %d", n)
+	}
+	for localPath := range newFiles {
+		if !env.queue.Contains(localPath) {
+			t.Errorf("%s was created while the daemon was down and should be queued", localPath)
+		}
+	}
+}
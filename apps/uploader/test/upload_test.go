@@ -1,6 +1,8 @@
 package test
 
 import (
+	"crypto/rand"
+	"os"
 	"path/filepath"
 	"testing"
 	"time"
@@ -43,3 +45,30 @@ func TestE2E_UploadAndVerify(t *testing.T) {
 
 	t.Logf("all %d files uploaded and verified successfully", len(testFiles))
 }
+
+func TestE2E_UploadLargeFileStreamed(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	localPath := filepath.Join(env.watchDir, "large.bin")
+	data := make([]byte, 8<<20)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("failed to generate random data: %v", err)
+	}
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	resp, err := env.uploader.Upload(localPath, "uploads/large.bin")
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if resp.Size != int64(len(data)) {
+		t.Errorf("expected size %d, got %d", len(data), resp.Size)
+	}
+
+	storagePath := env.storage.GetFilePath("test-client", "uploads/large.bin")
+	if hashFile(t, storagePath) != hashFile(t, localPath) {
+		t.Errorf("hash mismatch for streamed upload")
+	}
+}
@@ -0,0 +1,107 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"s3uploader/internal/server"
+)
+
+func TestE2E_VersionHistory(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.cleanup()
+
+	handler := server.NewHandler(env.storage, nil)
+	handler.SetMaxVersions(2)
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux, server.NewAuthMiddleware([]server.ClientEntry{
+		{ID: "test-client", APIKey: "test-api-key"},
+	}))
+	env.ts.Config.Handler = mux
+
+	localPath := filepath.Join(env.watchDir, "report.txt")
+	remotePath := "uploads/report.txt"
+	var hashes []string
+	for _, content := range []string{"version one", "version two", "version three"} {
+		if err := os.WriteFile(localPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		if _, err := env.uploader.Upload(localPath, remotePath); err != nil {
+			t.Fatalf("upload failed: %v", err)
+		}
+		sum := sha256.Sum256([]byte(content))
+		hashes = append(hashes, hex.EncodeToString(sum[:]))
+	}
+
+	// Pruning runs in the background after each upload.
+	var listing struct {
+		Versions []server.ObjectVersion `json:"versions"`
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp := getWithAuth(t, env.ts.URL+"/versions?"+url.Values{"path": {remotePath}}.Encode())
+		err := json.NewDecoder(resp.Body).Decode(&listing)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to decode versions: %v", err)
+		}
+		if len(listing.Versions) <= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if len(listing.Versions) != 2 {
+		t.Fatalf("expected 2 versions after pruning, got %d", len(listing.Versions))
+	}
+	if !listing.Versions[0].IsLatest || listing.Versions[1].IsLatest {
+		t.Errorf("only the newest version should be latest: %+v", listing.Versions)
+	}
+
+	for i, v := range listing.Versions {
+		query := url.Values{"path": {remotePath}, "version_id": {v.VersionID}}
+		resp := getWithAuth(t, env.ts.URL+"/download?"+query.Encode())
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to read version %s: %v", v.VersionID, err)
+		}
+
+		sum := sha256.Sum256(data)
+		expected := hashes[len(hashes)-1-i]
+		if actual := hex.EncodeToString(sum[:]); actual != expected {
+			t.Errorf("version %d: expected hash %s, got %s", i, expected, actual)
+		}
+	}
+
+	query := url.Values{"path": {remotePath}, "version_id": {"12345"}}
+	resp := getWithAuth(t, env.ts.URL+"/download?"+query.Encode())
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown version, got %d", resp.StatusCode)
+	}
+}
+
+func getWithAuth(t *testing.T, rawURL string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer test-api-key")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	return resp
+}